@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/hints/diff"
+	"github.com/Loofort/xscrape/hints/index"
 	"github.com/Loofort/xscrape/hints/scrape"
+	"github.com/Loofort/xscrape/hints/serve"
 	"github.com/Loofort/xscrape/iostuff"
+	"github.com/Loofort/xscrape/itunes"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -17,18 +26,90 @@ var (
 	scrapePriority = scrapeCmd.Flag("priority", "set minimum desired hint priority").Default("0").Short('p').Int16()
 	scrapeQuery    = scrapeCmd.Flag("query", "query file").Default("").Short('q').String()
 	scrapeOutput   = scrapeCmd.Flag("output", "hint file to write results").Default("").Short('o').String()
+	scrapeResume   = scrapeCmd.Flag("resume", "checkpoint directory, the crawl continues from its state if any").Default("").Short('r').String()
+	scrapeCountry  = scrapeCmd.Flag("country", "storefront country code").Default("").Short('c').String()
+	scrapeClient   = newCrawlFlags(scrapeCmd, ".frontier")
 
-	uniqCmd  = kingpin.Command("uniq", "extract unique hints")
-	uniqFile = uniqCmd.Arg("file", "hints file path").String()
+	retryCmd      = kingpin.Command("retry", "scrape again the queries from dead-letter file")
+	retryFile     = retryCmd.Arg("file", "dead-letter file path").Required().String()
+	retryPriority = retryCmd.Flag("priority", "set minimum desired hint priority").Default("0").Short('p').Int16()
+	retryOutput   = retryCmd.Flag("output", "hint file to append results").Default("").Short('o').String()
+	retryClient   = newCrawlFlags(retryCmd, ".retry.frontier")
 
-	leafCmd  = kingpin.Command("leaf", "extract hints leaves")
-	leafFile = leafCmd.Arg("file", "hints file path").String()
+	sortCmd    = kingpin.Command("sort", "sort hints file of any size by country, term and query")
+	sortFile   = sortCmd.Arg("file", "hints file path").String()
+	sortOutput = sortCmd.Flag("output", "file to write sorted hints").Default("").Short('o').String()
+	sortChunk  = sortCmd.Flag("chunk", "max lines kept in memory").Default("1000000").Int()
+	sortTmp    = sortCmd.Flag("tmp", "directory for the sorted chunks, default is system temp").Default("").String()
+
+	mergeCmd    = kingpin.Command("merge", "merge hints files from oldest to newest into one sorted file")
+	mergeFiles  = mergeCmd.Arg("files", "hints file paths, oldest first").Required().ExistingFiles()
+	mergeOutput = mergeCmd.Flag("output", "file to write merged hints").Default("").Short('o').String()
+	mergePolicy = mergeCmd.Flag("policy", "priority of the hint found in several files: latest, max or history").Default(hints.MergeLatest).Enum(hints.MergeLatest, hints.MergeMax, hints.MergeHistory)
+	mergeChunk  = mergeCmd.Flag("chunk", "max lines kept in memory").Default("1000000").Int()
+	mergeTmp    = mergeCmd.Flag("tmp", "directory for the temporary files, default is system temp").Default("").String()
+
+	diffCmd     = kingpin.Command("diff", "calculate difference between two hints files")
+	diffFile1   = diffCmd.Arg("file1", "older hints file path").Required().String()
+	diffFile2   = diffCmd.Arg("file2", "newer hints file path").Required().String()
+	diffSummary = diffCmd.Flag("summary", "print per term summary: delta, status, term, new/gone/changed/same queries").Bool()
+	diffAll     = diffCmd.Flag("all", "print the hints that didn't change too").Bool()
+	diffChunk   = diffCmd.Flag("chunk", "max lines kept in memory").Default("1000000").Int()
+	diffTmp     = diffCmd.Flag("tmp", "directory for the sorted snapshots, default is system temp").Default("").String()
+
+	uniqCmd    = kingpin.Command("uniq", "extract unique hints")
+	uniqFile   = uniqCmd.Arg("file", "hints file path").String()
+	uniqSorted = uniqCmd.Flag("sorted", "the file is already sorted by xhints sort").Bool()
+
+	leafCmd    = kingpin.Command("leaf", "extract hints leaves")
+	leafFile   = leafCmd.Arg("file", "hints file path").String()
+	leafSorted = leafCmd.Flag("sorted", "the file is already sorted by xhints sort").Bool()
+
+	indexCmd  = kingpin.Command("index", "build the query index next to hints file")
+	indexFile = indexCmd.Arg("file", "hints file path").Required().String()
+
+	lookupCmd    = kingpin.Command("lookup", "print hints of the query using the index")
+	lookupFile   = lookupCmd.Arg("file", "indexed hints file path").Required().String()
+	lookupQuery  = lookupCmd.Arg("query", "query to look up").Required().String()
+	lookupPrefix = lookupCmd.Flag("prefix", "print the scraped queries starting with query instead").Bool()
+
+	completeCmd     = kingpin.Command("complete", "print top terms for the prefix aggregated over all the queries starting with it")
+	completeFile    = completeCmd.Arg("file", "hints file path").Required().String()
+	completePrefix  = completeCmd.Arg("prefix", "query prefix").Required().String()
+	completeTop     = completeCmd.Flag("top", "number of terms").Default("10").Short('k').Int()
+	completeCountry = completeCmd.Flag("country", "storefront country code, all the countries are mixed if not set").Default("").Short('c').String()
+
+	serveCmd   = kingpin.Command("serve", "answer itunes hints requests from hints file, xml or json (format=json)")
+	serveFile  = serveCmd.Arg("file", "hints file path").Required().String()
+	serveAddr  = serveCmd.Flag("addr", "listen address").Default("127.0.0.1:8080").String()
+	serveLimit = serveCmd.Flag("limit", "max hints per response").Default("50").Int()
 
 	termCmd      = kingpin.Command("terms", "produce terms from hints")
 	termFile     = termCmd.Arg("file", "hints file path").String()
 	termPriority = termCmd.Flag("priority", "set minimum desired hint priority").Default("0").Short('p').Int16()
+	termSkipBad  = termCmd.Flag("skip-bad", "skip malformed lines instead of failing").Bool()
 )
 
+// itunes client and workers flags shared by scrape and retry commands
+type crawlFlags struct {
+	client   *itunes.ClientFlags
+	workers  *int
+	frontier *string
+
+	// derives the frontier name from output
+	frontierSuffix string
+}
+
+// the scrape and retry frontiers differ in format, so their default names differ too
+func newCrawlFlags(cmd *kingpin.CmdClause, frontierSuffix string) crawlFlags {
+	return crawlFlags{
+		client:         itunes.NewCrawlFlags(cmd, "query", "queries", 10),
+		workers:        cmd.Flag("workers", "number of concurrent scrapers").Default("10").Short('w').Int(),
+		frontier:       cmd.Flag("frontier", "file to save unprocessed queries on interrupt, default is output file + "+frontierSuffix).Default("").String(),
+		frontierSuffix: frontierSuffix,
+	}
+}
+
 func check(err error) {
 	if err != nil {
 		log.Print(err)
@@ -39,40 +120,187 @@ func check(err error) {
 func main() {
 	switch kingpin.Parse() {
 	case "scrape":
-		Scrape(*scrapeQuery, *scrapeOutput, *scrapePriority)
+		cfg, closeCfg := scrapeClient.config()
+		defer closeCfg()
+		cfg.Country = *scrapeCountry
+		frontier := scrapeClient.frontierFile(*scrapeOutput)
+		Scrape(cfg, *scrapeClient.workers, *scrapeQuery, *scrapeOutput, *scrapeResume, frontier, *scrapePriority)
+	case "retry":
+		cfg, closeCfg := retryClient.config()
+		defer closeCfg()
+		frontier := retryClient.frontierFile(*retryOutput)
+		Retry(cfg, *retryClient.workers, *retryFile, *retryOutput, frontier, *retryPriority)
+	case "sort":
+		Sort(*sortFile, *sortOutput, *sortChunk, *sortTmp)
+	case "merge":
+		Merge(*mergeFiles, *mergeOutput, *mergePolicy, *mergeChunk, *mergeTmp)
+	case "diff":
+		Diff(*diffFile1, *diffFile2, *diffSummary, *diffAll, *diffChunk, *diffTmp)
 	case "uniq":
-		Uniq(*uniqFile)
+		Uniq(*uniqFile, *uniqSorted)
 	case "leaf":
-		Leaf(*leafFile)
+		Leaf(*leafFile, *leafSorted)
 	case "terms":
-		Term(*termFile, *termPriority)
+		Term(*termFile, *termPriority, *termSkipBad)
+	case "index":
+		check(index.Build(*indexFile))
+	case "lookup":
+		Lookup(*lookupFile, *lookupQuery, *lookupPrefix)
+	case "complete":
+		Complete(*completeFile, *completePrefix, *completeCountry, *completeTop)
+	case "serve":
+		Serve(*serveFile, *serveAddr, *serveLimit)
 	}
 }
 
-func Scrape(queryfile, hintsfile string, priority int16) {
-	pipe, wait := scrapePipe(queryfile)
+func (f crawlFlags) frontierFile(output string) string {
+	return iostuff.DefaultFile(*f.frontier, output, f.frontierSuffix)
+}
+
+// returns config and the func closing its dead-letter and archive files
+func (f crawlFlags) config() (itunes.Config, func()) {
+	if *f.workers < 1 {
+		check(fmt.Errorf("--workers should be at least 1, got %d", *f.workers))
+	}
+	cfg, closeCfg, err := f.client.Config()
+	check(err)
+	return cfg, func() { check(closeCfg()) }
+}
+
+func Scrape(cfg itunes.Config, workers int, queryfile, hintsfile, resumedir, frontier string, priority int16) {
+	pipe, wait := scrapePipe(queryfile, resumedir)
 
 	storage, err := iostuff.OutputWriter(hintsfile)
 	check(err)
 	defer storage.Close()
 
-	for i := 0; i < 10; i++ {
-		go func() {
-			var err error
-			finish := false
-			for !finish {
-				finish, err = scrape.Iterate(pipe, storage, priority)
-				if err != nil {
-					log.Printf("%v\n", err)
+	rest, interrupted := crawl(cfg, workers, pipe, wait, storage, priority)
+	if !interrupted {
+		return
+	}
+	if resumedir != "" {
+		log.Printf("the crawl state is kept in %s\n", resumedir)
+		return
+	}
+
+	// the frontier could be used as query file to continue
+	saveFrontier(frontier, len(rest), func(w io.Writer) error {
+		for _, q := range rest {
+			if _, err := fmt.Fprintln(w, q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Retry scrapes the failed queries grouped by their countries.
+// the crawl continues down from each query as it would in the original run.
+func Retry(cfg itunes.Config, workers int, deadfile, hintsfile, frontier string, priority int16) {
+	r, err := iostuff.InputReader(deadfile)
+	check(err)
+	fs, err := itunes.FailuresFromReader(r)
+	r.Close()
+	check(err)
+
+	countries := []string{}
+	queries := map[string][]string{}
+	seen := map[itunes.Failure]struct{}{}
+	for _, f := range fs {
+		key := itunes.Failure{Query: f.Query, Country: f.Country}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		if _, ok := queries[f.Country]; !ok {
+			countries = append(countries, f.Country)
+		}
+		queries[f.Country] = append(queries[f.Country], f.Query)
+	}
+
+	storage, err := iostuff.OutputWriter(hintsfile)
+	check(err)
+	defer storage.Close()
+
+	for i, country := range countries {
+		cfg.Country = country
+		pipe, wait := iostuff.NewBufferPipe(queries[country])
+		rest, interrupted := crawl(cfg, workers, pipe, wait, storage, priority)
+		if !interrupted {
+			continue
+		}
+
+		// the frontier is dead-letter file to retry again
+		left := []itunes.Failure{}
+		for _, q := range rest {
+			left = append(left, itunes.Failure{Query: q, Country: country})
+		}
+		for _, country := range countries[i+1:] {
+			for _, q := range queries[country] {
+				left = append(left, itunes.Failure{Query: q, Country: country})
+			}
+		}
+		saveFrontier(frontier, len(left), func(w io.Writer) error {
+			dl := itunes.NewDeadLetter(w)
+			for _, f := range left {
+				f.Class = "interrupted"
+				f.Time = time.Now()
+				if err := dl.Write(f); err != nil {
+					return err
 				}
 			}
-		}()
+			return nil
+		})
+		return
+	}
+}
+
+// returns the unprocessed queries and true if the crawl was interrupted by signal
+func crawl(cfg itunes.Config, workers int, pipe iostuff.Pipe, wait func() error, storage io.Writer, priority int16) ([]string, bool) {
+	iterate := func() (bool, error) {
+		return scrape.Iterate(cfg, pipe, storage, priority)
+	}
+	report := func(err error) {
+		log.Printf("%v\n", err)
+	}
+	join := iostuff.Workers(workers, iterate, report)
+	stop := iostuff.CloseOnSignal(pipe, func(sig os.Signal) {
+		log.Printf("%v: finishing in-flight queries\n", sig)
+	})
+	defer stop()
+
+	err := wait()
+	join()
+	if err != iostuff.Closed {
+		check(err)
+		return nil, false
+	}
+
+	if r, ok := pipe.(iostuff.Rester); ok {
+		return r.Rest(), true
+	}
+	return nil, true
+}
+
+func saveFrontier(frontier string, count int, write func(io.Writer) error) {
+	if count == 0 {
+		return
+	}
+	if frontier == "" {
+		log.Printf("%d unprocessed queries are lost, set --frontier to keep them\n", count)
+		return
 	}
 
-	wait()
+	check(iostuff.SaveFrontier(frontier, write))
+	log.Printf("%d unprocessed queries are saved to %s\n", count, frontier)
 }
 
-func scrapePipe(queryfile string) (iostuff.Pipe, func() error) {
+func scrapePipe(queryfile, resumedir string) (iostuff.Pipe, func() error) {
+	if resumedir != "" {
+		return checkpointPipe(queryfile, resumedir)
+	}
+
 	r, err := iostuff.InputReader(queryfile)
 	check(err)
 
@@ -81,68 +309,230 @@ func scrapePipe(queryfile string) (iostuff.Pipe, func() error) {
 	}
 
 	qs := scrape.Generate("")
-	return iostuff.NewMemPipe(qs)
+	return iostuff.NewBufferPipe(qs)
 }
 
-func Term(hintsfile string, priority int16) {
+// the seed is used only if the checkpoint is empty
+func checkpointPipe(queryfile, resumedir string) (iostuff.Pipe, func() error) {
+	qs, err := iostuff.InputLines(queryfile)
+	check(err)
+	if qs == nil {
+		qs = scrape.Generate("")
+	}
+
+	pipe, wait, err := scrape.NewCheckpoint(resumedir, qs)
+	check(err)
+	return pipe, wait
+}
+
+func Lookup(hintsfile, q string, prefix bool) {
+	ix, err := index.Open(hintsfile)
+	check(err)
+	defer ix.Close()
+
+	if prefix {
+		qs, err := ix.Queries(q)
+		check(err)
+		for _, query := range qs {
+			fmt.Println(query)
+		}
+		return
+	}
+
+	hs, err := ix.Hints(q)
+	check(err)
+	for _, hint := range hs {
+		fmt.Println(hint)
+	}
+}
+
+func Complete(hintsfile, prefix, country string, k int) {
 	r, err := iostuff.InputReader(hintsfile)
 	check(err)
 	defer r.Close()
 
-	hs, err := hints.FromReader(r)
+	it, err := hints.NewCompleteIndex(r, k)
 	check(err)
 
+	hs := it.Complete(prefix, k)
+	if country != "" {
+		hs = it.CompleteCountry(prefix, country, k)
+	}
 	for _, hint := range hs {
-		if hint.Priority >= priority {
+		fmt.Println(hint)
+	}
+}
+
+func Serve(hintsfile, addr string, limit int) {
+	r, err := iostuff.InputReader(hintsfile)
+	check(err)
+	it, err := hints.NewCompleteIndex(r, serve.MaxHints)
+	r.Close()
+	check(err)
+
+	log.Printf("serving %s on http://%s%s?q=\n", hintsfile, addr, serve.HintsPath)
+	check(http.ListenAndServe(addr, serve.Handler(it, limit)))
+}
+
+func Term(hintsfile string, priority int16, skipBad bool) {
+	r, err := iostuff.InputReader(hintsfile)
+	check(err)
+	defer r.Close()
+
+	hr := hints.NewReader(r)
+	hr.SkipBad = skipBad
+	for hr.Next() {
+		if hint := hr.Hint(); hint.Priority >= priority {
 			fmt.Println(hint.Term)
 		}
 	}
+	check(hr.Err())
+
+	if hr.Skipped() > 0 {
+		log.Printf("%d malformed lines are skipped\n", hr.Skipped())
+	}
 }
 
-func Uniq(filename string) {
-	hs := sortedHints(filename)
-	hint := hs[0]
-	for _, t := range hs[1:] {
-		if t.Term != hint.Term {
-			fmt.Println(hint)
-			hint = t
-			continue
+func Sort(hintsfile, output string, chunk int, tmpdir string) {
+	r, err := iostuff.InputReader(hintsfile)
+	check(err)
+	defer r.Close()
+
+	w := os.Stdout
+	if output != "" {
+		w, err = os.Create(output)
+		check(err)
+		defer w.Close()
+	}
+
+	check(hints.SortLines(r, w, chunk, tmpdir))
+}
+
+func Merge(hintsfiles []string, output, policy string, chunk int, tmpdir string) {
+	rs := []io.Reader{}
+	for _, hintsfile := range hintsfiles {
+		f, err := os.Open(hintsfile)
+		check(err)
+		defer f.Close()
+		rs = append(rs, f)
+	}
+
+	w := os.Stdout
+	if output != "" {
+		var err error
+		w, err = os.Create(output)
+		check(err)
+		defer w.Close()
+	}
+
+	check(hints.Merge(rs, w, policy, chunk, tmpdir))
+}
+
+func Diff(hintsfile1, hintsfile2 string, summary, all bool, chunk int, tmpdir string) {
+	r1, err := iostuff.InputReader(hintsfile1)
+	check(err)
+	defer r1.Close()
+
+	r2, err := iostuff.InputReader(hintsfile2)
+	check(err)
+	defer r2.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if summary {
+		s := diff.NewSummarizer(func(sm diff.Summary) error {
+			if all || sm.Status != diff.Same {
+				_, err := fmt.Fprintln(w, sm)
+				return err
+			}
+			return nil
+		})
+		check(diff.Diff(r1, r2, chunk, tmpdir, s.Add))
+		check(s.Flush())
+		return
+	}
+
+	check(diff.Diff(r1, r2, chunk, tmpdir, func(ch diff.Change) error {
+		if all || ch.Status != diff.Same {
+			_, err := fmt.Fprintln(w, ch)
+			return err
+		}
+		return nil
+	}))
+}
+
+func Uniq(filename string, sorted bool) {
+	var hint *hints.Hint
+	walkSorted(filename, sorted, func(t hints.Hint) {
+		if hint == nil {
+			hint = &t
+			return
+		}
+		if t.Term != hint.Term || t.Country != hint.Country {
+			fmt.Println(*hint)
+			*hint = t
+			return
 		}
 
 		if t.Priority > hint.Priority {
-			hint = t
+			*hint = t
 		}
+	})
+	if hint != nil {
+		fmt.Println(*hint)
 	}
 }
 
-func Leaf(filename string) {
-	hs := sortedHints(filename)
-	hint := hs[0]
-	for _, t := range hs[1:] {
-		if t.Term != hint.Term {
-			fmt.Println(hint)
-			hint = t
-			continue
+func Leaf(filename string, sorted bool) {
+	var hint *hints.Hint
+	walkSorted(filename, sorted, func(t hints.Hint) {
+		if hint == nil {
+			hint = &t
+			return
+		}
+		if t.Term != hint.Term || t.Country != hint.Country {
+			fmt.Println(*hint)
+			*hint = t
+			return
 		}
 
 		if strings.HasPrefix(t.Query, hint.Query) {
-			hint = t
-			continue
+			*hint = t
+			return
 		}
 
-		fmt.Println(hint)
-		hint = t
+		fmt.Println(*hint)
+		*hint = t
+	})
+	if hint != nil {
+		fmt.Println(*hint)
 	}
 }
 
-func sortedHints(hintsfile string) []hints.Hint {
+// calls foo for every hint in Sort order.
+// the unsorted file is sorted to temporary file first, so it's never loaded into memory.
+func walkSorted(hintsfile string, sorted bool, foo func(hints.Hint)) {
 	r, err := iostuff.InputReader(hintsfile)
 	check(err)
 	defer r.Close()
 
-	hs, err := hints.FromReader(r)
-	check(err)
+	var in io.Reader = r
+	if !sorted {
+		tmp, err := ioutil.TempFile("", "hints")
+		check(err)
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		check(hints.SortLines(r, tmp, 0, ""))
+		_, err = tmp.Seek(0, 0)
+		check(err)
+		in = tmp
+	}
 
-	hints.Sort(hs)
-	return hs
+	hr := hints.NewReader(in)
+	for hr.Next() {
+		foo(hr.Hint())
+	}
+	check(hr.Err())
 }
@@ -1,28 +1,97 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Loofort/xscrape/iostuff"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/search"
 	"github.com/Loofort/xscrape/search/diff"
+	"github.com/Loofort/xscrape/search/history"
+	"github.com/Loofort/xscrape/search/lookup"
 	"github.com/Loofort/xscrape/search/scrape"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	scrapeCmd    = kingpin.Command("scrape", "scrape itunes search")
-	scrapeInput  = scrapeCmd.Flag("input", "term file").Default("").Short('i').String()
-	scrapeOutput = scrapeCmd.Flag("output", "hint file to write results").Default("").Short('o').String()
+	scrapeCmd     = kingpin.Command("scrape", "scrape itunes search")
+	scrapeInput   = scrapeCmd.Flag("input", "term file").Default("").Short('i').String()
+	scrapeOutput  = scrapeCmd.Flag("output", "hint file to write results").Default("").Short('o').String()
+	scrapeCountry = scrapeCmd.Flag("country", "storefront country code").Default("").Short('c').String()
+	scrapeClient  = newCrawlFlags(scrapeCmd, ".frontier")
+
+	retryCmd    = kingpin.Command("retry", "scrape again the terms from dead-letter file")
+	retryFile   = retryCmd.Arg("file", "dead-letter file path").Required().String()
+	retryOutput = retryCmd.Flag("output", "search file to append results").Default("").Short('o').String()
+	retryClient = newCrawlFlags(retryCmd, ".retry.frontier")
+
+	sortCmd    = kingpin.Command("sort", "sort search file of any size by term")
+	sortFile   = sortCmd.Arg("file", "search file path").String()
+	sortOutput = sortCmd.Flag("output", "file to write sorted search").Default("").Short('o').String()
+	sortChunk  = sortCmd.Flag("chunk", "max lines kept in memory").Default("1000000").Int()
+	sortTmp    = sortCmd.Flag("tmp", "directory for the sorted chunks, default is system temp").Default("").String()
+
+	historyCmd    = kingpin.Command("history", "build position time series of term bundles over snapshots")
+	historyFiles  = historyCmd.Arg("files", "search files from oldest to newest, or the directory of them ordered by name").Required().Strings()
+	historyFormat = historyCmd.Flag("format", "output format: csv or json").Default("csv").Enum("csv", "json")
+	historyTop    = historyCmd.Flag("top", "n of days-in-top-n column").Default("10").Short('n').Int()
+	historyOutput = historyCmd.Flag("output", "file to write series").Default("").Short('o').String()
+
+	lookupCmd     = kingpin.Command("lookup", "look up apps metadata of bundle or track ids, the result is apps file")
+	lookupInput   = lookupCmd.Flag("input", "id file, one per line, or search file").Default("").Short('i').String()
+	lookupOutput  = lookupCmd.Flag("output", "apps file to append results").Default("").Short('o').String()
+	lookupCountry = lookupCmd.Flag("country", "storefront country code").Default("").Short('c').String()
+	lookupTracks  = lookupCmd.Flag("track", "the ids are track ids").Bool()
+	lookupClient  = itunes.NewClientFlags(lookupCmd, "request", 0.33)
+
+	appsCmd     = kingpin.Command("apps", "query the apps metadata file")
+	appsPath    = appsCmd.Arg("file", "apps file path").String()
+	appsBundles = appsCmd.Flag("bundle", "bundle id to print, repeatable").Short('b').Strings()
+	appsTracks  = appsCmd.Flag("track", "track id to print, repeatable").Short('t').Ints()
+	appsLatest  = appsCmd.Flag("latest", "print only the latest record of every app").Bool()
+	appsFields  = appsCmd.Flag("fields", "comma separated json fields to print as tab separated columns instead of json").Default("").Short('f').String()
+
+	appdiffCmd   = kingpin.Command("appdiff", "calculate app metadata changes between two apps files: kind, bundle, delta, old, new")
+	appdiffFile1 = appdiffCmd.Arg("file1", "older apps file path").Required().String()
+	appdiffFile2 = appdiffCmd.Arg("file2", "newer apps file path").Required().String()
+	appdiffKinds = appdiffCmd.Flag("kind", "change kind to print, repeatable, e.g. version or price").Short('k').Strings()
 
 	diffCmd   = kingpin.Command("diff", "calculate difference between two search files")
 	diffFile1 = diffCmd.Arg("file1", "search 1 file path").String()
 	diffFile2 = diffCmd.Arg("file2", "search 2 file path").String()
 )
 
+// itunes client and workers flags shared by scrape and retry commands
+type crawlFlags struct {
+	client   *itunes.ClientFlags
+	workers  *int
+	frontier *string
+	apps     *string
+
+	// derives the frontier name from output
+	frontierSuffix string
+}
+
+// the scrape and retry frontiers differ in format, so their default names differ too
+func newCrawlFlags(cmd *kingpin.CmdClause, frontierSuffix string) crawlFlags {
+	return crawlFlags{
+		client:         itunes.NewCrawlFlags(cmd, "term", "terms", 0.33),
+		workers:        cmd.Flag("workers", "number of concurrent scrapers").Default("1").Short('w').Int(),
+		frontier:       cmd.Flag("frontier", "file to save unprocessed terms on interrupt, default is output file + "+frontierSuffix).Default("").String(),
+		apps:           cmd.Flag("apps", "file to append the apps metadata to, default is output file + .apps").Default("").String(),
+		frontierSuffix: frontierSuffix,
+	}
+}
+
 func check(err error) {
 	if err != nil {
 		log.Print(err)
@@ -33,12 +102,265 @@ func check(err error) {
 func main() {
 	switch kingpin.Parse() {
 	case "scrape":
-		Scrape(*scrapeInput, *scrapeOutput)
+		cfg, closeCfg := scrapeClient.config()
+		defer closeCfg()
+		cfg.Country = *scrapeCountry
+		frontier := scrapeClient.frontierFile(*scrapeOutput)
+		apps := iostuff.DefaultFile(*scrapeClient.apps, *scrapeOutput, ".apps")
+		Scrape(cfg, *scrapeClient.workers, *scrapeInput, *scrapeOutput, frontier, apps)
+	case "retry":
+		cfg, closeCfg := retryClient.config()
+		defer closeCfg()
+		frontier := retryClient.frontierFile(*retryOutput)
+		apps := iostuff.DefaultFile(*retryClient.apps, *retryOutput, ".apps")
+		Retry(cfg, *retryClient.workers, *retryFile, *retryOutput, frontier, apps)
+	case "sort":
+		Sort(*sortFile, *sortOutput, *sortChunk, *sortTmp)
+	case "history":
+		History(*historyFiles, *historyFormat, *historyTop, *historyOutput)
+	case "lookup":
+		cfg, closeCfg, err := lookupClient.Config()
+		check(err)
+		defer func() { check(closeCfg()) }()
+		cfg.Country = *lookupCountry
+		Lookup(cfg, *lookupInput, *lookupOutput, *lookupTracks)
+	case "apps":
+		Apps(*appsPath, *appsBundles, *appsTracks, *appsLatest, *appsFields)
+	case "appdiff":
+		AppDiff(*appdiffFile1, *appdiffFile2, *appdiffKinds)
 	case "diff":
 		Diff(*diffFile1, *diffFile2)
 	}
 }
 
+func (f crawlFlags) frontierFile(output string) string {
+	return iostuff.DefaultFile(*f.frontier, output, f.frontierSuffix)
+}
+
+// returns config and the func closing its dead-letter and archive files
+func (f crawlFlags) config() (itunes.Config, func()) {
+	if *f.workers < 1 {
+		check(fmt.Errorf("--workers should be at least 1, got %d", *f.workers))
+	}
+	cfg, closeCfg, err := f.client.Config()
+	check(err)
+	return cfg, func() { check(closeCfg()) }
+}
+
+func Sort(searchfile, output string, chunk int, tmpdir string) {
+	r, err := iostuff.InputReader(searchfile)
+	check(err)
+	defer r.Close()
+
+	w := os.Stdout
+	if output != "" {
+		w, err = os.Create(output)
+		check(err)
+		defer w.Close()
+	}
+
+	check(search.SortLines(r, w, chunk, tmpdir))
+}
+
+func Lookup(cfg itunes.Config, idfile, appsfile string, tracks bool) {
+	lines, err := iostuff.InputLines(idfile)
+	check(err)
+
+	// the search file line is term and its bundles
+	ids := []string{}
+	seen := map[string]struct{}{}
+	for _, line := range lines {
+		lineIDs := []string{strings.TrimSpace(line)}
+		if strings.Contains(line, "\t") {
+			ss, err := search.ParseLine(line)
+			check(err)
+			lineIDs = lineIDs[:0]
+			for _, s := range ss {
+				lineIDs = append(lineIDs, s.BundleID)
+			}
+		}
+
+		for _, id := range lineIDs {
+			if _, ok := seen[id]; ok || id == "" {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	w, err := iostuff.OutputWriter(appsfile)
+	check(err)
+	defer w.Close()
+	aw := search.NewAppWriter(w)
+
+	var apps []search.App
+	if tracks {
+		tids := make([]int, 0, len(ids))
+		for _, id := range ids {
+			tid, err := strconv.Atoi(id)
+			check(err)
+			tids = append(tids, tid)
+		}
+		apps, err = lookup.Tracks(cfg, tids)
+	} else {
+		apps, err = lookup.Bundles(cfg, ids)
+	}
+
+	// the apps found before the error are kept
+	check(aw.Write(cfg.Country, apps))
+	check(err)
+	log.Printf("%d of %d ids are found\n", len(apps), len(ids))
+}
+
+func Apps(appsfile string, bundles []string, tracks []int, latest bool, fields string) {
+	r, err := iostuff.InputReader(appsfile)
+	check(err)
+	defer r.Close()
+
+	match := func(rec search.AppRecord) bool {
+		if len(bundles) == 0 && len(tracks) == 0 {
+			return true
+		}
+		for _, bundle := range bundles {
+			if rec.BundleID == bundle {
+				return true
+			}
+		}
+		for _, track := range tracks {
+			if rec.TrackID == track {
+				return true
+			}
+		}
+		return false
+	}
+
+	printRecord := func(rec search.AppRecord) {
+		b, err := json.Marshal(rec)
+		check(err)
+		if fields == "" {
+			fmt.Printf("%s\n", b)
+			return
+		}
+
+		m := map[string]interface{}{}
+		check(json.Unmarshal(b, &m))
+		cols := []string{}
+		for _, field := range strings.Split(fields, ",") {
+			v, ok := m[strings.TrimSpace(field)]
+			if !ok || v == nil {
+				cols = append(cols, "")
+				continue
+			}
+			if s, ok := v.(string); ok {
+				cols = append(cols, s)
+				continue
+			}
+			vb, err := json.Marshal(v)
+			check(err)
+			cols = append(cols, string(vb))
+		}
+		fmt.Println(strings.Join(cols, "\t"))
+	}
+
+	// the latest records are printed in order of the apps first appearance
+	order := []string{}
+	last := map[string]search.AppRecord{}
+	ar := search.NewAppReader(r)
+	for ar.Next() {
+		rec := ar.Record()
+		if !match(rec) {
+			continue
+		}
+		if !latest {
+			printRecord(rec)
+			continue
+		}
+
+		key := rec.Country + " " + rec.BundleID + " " + strconv.Itoa(rec.TrackID)
+		if _, ok := last[key]; !ok {
+			order = append(order, key)
+		}
+		last[key] = rec
+	}
+	check(ar.Err())
+
+	for _, key := range order {
+		printRecord(last[key])
+	}
+}
+
+func History(paths []string, format string, top int, output string) {
+	files := paths
+	if len(paths) == 1 {
+		if fi, err := os.Stat(paths[0]); err == nil && fi.IsDir() {
+			fis, err := ioutil.ReadDir(paths[0])
+			check(err)
+			files = nil
+			// ReadDir returns the files sorted by name, the date named snapshots are in order
+			for _, fi := range fis {
+				if !fi.IsDir() {
+					files = append(files, filepath.Join(paths[0], fi.Name()))
+				}
+			}
+		}
+	}
+
+	labels := []string{}
+	rs := []io.Reader{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		check(err)
+		defer f.Close()
+		rs = append(rs, f)
+		labels = append(labels, filepath.Base(file))
+	}
+
+	ss, err := history.Build(rs)
+	check(err)
+
+	w := os.Stdout
+	if output != "" {
+		w, err = os.Create(output)
+		check(err)
+		defer w.Close()
+	}
+
+	if format == "json" {
+		check(history.WriteJSON(w, labels, ss, top))
+		return
+	}
+	check(history.WriteCSV(w, labels, ss, top))
+}
+
+func AppDiff(appsfile1, appsfile2 string, kinds []string) {
+	r1, err := iostuff.InputReader(appsfile1)
+	check(err)
+	defer r1.Close()
+
+	r2, err := iostuff.InputReader(appsfile2)
+	check(err)
+	defer r2.Close()
+
+	chs, err := diff.DiffApps(r1, r2)
+	check(err)
+
+	for _, ch := range chs {
+		if len(kinds) == 0 || hasKind(kinds, ch.Kind) {
+			fmt.Println(ch)
+		}
+	}
+}
+
+func hasKind(kinds []string, kind diff.AppChangeKind) bool {
+	for _, k := range kinds {
+		if diff.AppChangeKind(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
 func Diff(searchfile1, searchfile2 string) {
 	r1, err := iostuff.InputReader(searchfile1)
 	check(err)
@@ -56,7 +378,7 @@ func Diff(searchfile1, searchfile2 string) {
 	}
 }
 
-func Scrape(termfile, searchesfile string) {
+func Scrape(cfg itunes.Config, workers int, termfile, searchesfile, frontier, appsfile string) {
 	r, err := iostuff.InputReader(termfile)
 	check(err)
 	defer r.Close()
@@ -66,22 +388,135 @@ func Scrape(termfile, searchesfile string) {
 	check(err)
 	defer storage.Close()
 
-	for i := 0; i < 1; i++ {
-		go func() {
-			var err error
-			finish := false
-			sleep := time.Minute / 20
-			for !finish {
-				start := time.Now()
-				finish, err = scrape.Iterate(http.DefaultClient, pipe, storage, "")
-				if err != nil {
-					log.Printf("%v\n", err)
+	apps, closeApps := appWriter(appsfile)
+	defer closeApps()
+
+	rest, interrupted := run(cfg, workers, pipe, wait, storage, apps)
+	if !interrupted {
+		return
+	}
+
+	// the frontier could be used as term file to continue
+	saveFrontier(frontier, len(rest), func(w io.Writer) error {
+		for _, term := range rest {
+			if _, err := fmt.Fprintln(w, term); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Retry scrapes the failed terms grouped by their countries
+func Retry(cfg itunes.Config, workers int, deadfile, searchesfile, frontier, appsfile string) {
+	r, err := iostuff.InputReader(deadfile)
+	check(err)
+	fs, err := itunes.FailuresFromReader(r)
+	r.Close()
+	check(err)
+
+	countries := []string{}
+	terms := map[string][]string{}
+	seen := map[itunes.Failure]struct{}{}
+	for _, f := range fs {
+		key := itunes.Failure{Query: f.Query, Country: f.Country}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		if _, ok := terms[f.Country]; !ok {
+			countries = append(countries, f.Country)
+		}
+		terms[f.Country] = append(terms[f.Country], f.Query)
+	}
+
+	storage, err := iostuff.OutputWriter(searchesfile)
+	check(err)
+	defer storage.Close()
+
+	apps, closeApps := appWriter(appsfile)
+	defer closeApps()
+
+	for i, country := range countries {
+		cfg.Country = country
+		pipe, wait := iostuff.NewBufferPipe(terms[country])
+		rest, interrupted := run(cfg, workers, pipe, wait, storage, apps)
+		if !interrupted {
+			continue
+		}
+
+		// the frontier is dead-letter file to retry again
+		left := []itunes.Failure{}
+		for _, term := range rest {
+			left = append(left, itunes.Failure{Query: term, Country: country})
+		}
+		for _, country := range countries[i+1:] {
+			for _, term := range terms[country] {
+				left = append(left, itunes.Failure{Query: term, Country: country})
+			}
+		}
+		saveFrontier(frontier, len(left), func(w io.Writer) error {
+			dl := itunes.NewDeadLetter(w)
+			for _, f := range left {
+				f.Class = "interrupted"
+				f.Time = time.Now()
+				if err := dl.Write(f); err != nil {
+					return err
 				}
-				took := time.Since(start)
-				time.Sleep(sleep - took)
 			}
-		}()
+			return nil
+		})
+		return
+	}
+}
+
+// returns the unprocessed terms and true if the run was interrupted by signal
+func run(cfg itunes.Config, workers int, pipe iostuff.Pipe, wait func() error, storage io.Writer, apps *search.AppWriter) ([]string, bool) {
+	iterate := func() (bool, error) {
+		return scrape.Iterate(cfg, pipe, storage, apps)
+	}
+	report := func(err error) {
+		log.Printf("%v\n", err)
+	}
+	join := iostuff.Workers(workers, iterate, report)
+	stop := iostuff.CloseOnSignal(pipe, func(sig os.Signal) {
+		log.Printf("%v: finishing in-flight terms\n", sig)
+	})
+	defer stop()
+
+	err := wait()
+	join()
+	if err != iostuff.Closed {
+		check(err)
+		return nil, false
+	}
+
+	if r, ok := pipe.(iostuff.Rester); ok {
+		return r.Rest(), true
+	}
+	return nil, true
+}
+
+// returns nil writer if the apps file isn't set
+func appWriter(appsfile string) (*search.AppWriter, func()) {
+	if appsfile == "" {
+		return nil, func() {}
+	}
+	w, err := iostuff.OutputWriter(appsfile)
+	check(err)
+	return search.NewAppWriter(w), func() { w.Close() }
+}
+
+func saveFrontier(frontier string, count int, write func(io.Writer) error) {
+	if count == 0 {
+		return
+	}
+	if frontier == "" {
+		log.Printf("%d unprocessed terms are lost, set --frontier to keep them\n", count)
+		return
 	}
 
-	wait()
+	check(iostuff.SaveFrontier(frontier, write))
+	log.Printf("%d unprocessed terms are saved to %s\n", count, frontier)
 }
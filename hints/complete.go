@@ -0,0 +1,186 @@
+package hints
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// NewCompleteIndex builds the tree from hints reader and precomputes top k terms of every node
+// for every storefront country, so Complete answers without walking the subtree.
+// The hints could be in any order, e.g. scraped or sorted by xhints sort,
+// the query could be repeated (e.g. the merged crawls).
+// The own hints of the query are ordered by priority, highest first, like itunes gives them;
+// the term repeated for the query is taken once with its last priority.
+func NewCompleteIndex(r io.Reader, k int) (*IndexTree, error) {
+	root := &IndexTree{}
+
+	hr := NewReader(r)
+	for hr.Next() {
+		hint := hr.Hint()
+		q := []byte(hint.Query)
+		node, ok := FindNode(root, q)
+		if !ok || !node.added {
+			node = AddNode(root, q, 0)
+		}
+		if node.hints == nil {
+			node.hints = map[string][]Hint{}
+			node.tops = map[string][]Hint{}
+		}
+		node.hints[hint.Country] = append(node.hints[hint.Country], hint)
+		node.tops[hint.Country] = append(node.tops[hint.Country], hint)
+	}
+	if err := hr.Err(); err != nil {
+		return nil, err
+	}
+
+	fillTop(root, k)
+	return root, nil
+}
+
+// merges own hints of the node with the children tops per country, post order.
+// the own hints are put in priority order too.
+func fillTop(node *IndexTree, k int) {
+	for country, own := range node.hints {
+		node.hints[country] = queryHints(own)
+	}
+
+	hs := map[string][]Hint{}
+	for country, top := range node.tops {
+		hs[country] = top
+	}
+	for _, child := range node.childs {
+		fillTop(child, k)
+		for country, top := range child.tops {
+			hs[country] = append(hs[country], top...)
+		}
+	}
+
+	if len(hs) == 0 {
+		return
+	}
+	node.tops = map[string][]Hint{}
+	for country, top := range hs {
+		node.tops[country] = topHints(top, k)
+	}
+}
+
+// returns k hints with highest priority,
+// the term is taken once per country with its max priority
+func topHints(hs []Hint, k int) []Hint {
+	type key struct{ country, term string }
+	best := map[key]int{}
+	uniq := []Hint{}
+	for _, h := range hs {
+		i, ok := best[key{h.Country, h.Term}]
+		if !ok {
+			best[key{h.Country, h.Term}] = len(uniq)
+			uniq = append(uniq, h)
+			continue
+		}
+		if h.Priority > uniq[i].Priority {
+			uniq[i] = h
+		}
+	}
+
+	sort.Slice(uniq, func(i, j int) bool {
+		if uniq[i].Priority != uniq[j].Priority {
+			return uniq[i].Priority > uniq[j].Priority
+		}
+		if uniq[i].Term != uniq[j].Term {
+			return uniq[i].Term < uniq[j].Term
+		}
+		return uniq[i].Country < uniq[j].Country
+	})
+	if len(uniq) > k {
+		uniq = uniq[:k:k]
+	}
+	return uniq
+}
+
+// returns the hints with the term taken once with its last priority, ordered by priority, highest first
+func queryHints(hs []Hint) []Hint {
+	last := map[string]int{}
+	uniq := []Hint{}
+	for _, h := range hs {
+		if i, ok := last[h.Term]; ok {
+			uniq[i] = h
+			continue
+		}
+		last[h.Term] = len(uniq)
+		uniq = append(uniq, h)
+	}
+
+	sort.SliceStable(uniq, func(i, j int) bool {
+		return uniq[i].Priority > uniq[j].Priority
+	})
+	return uniq
+}
+
+// Complete returns top k terms by priority among the hints of all the queries starting with prefix.
+// The prefix doesn't have to be scraped itself.
+// The countries are mixed, the same term could come from several storefronts.
+// k is limited by the one the index was built with.
+func (it *IndexTree) Complete(prefix string, k int) []Hint {
+	node := findPrefix(it, []byte(prefix))
+	if node == nil {
+		return nil
+	}
+
+	hs := []Hint{}
+	for _, top := range node.tops {
+		hs = append(hs, top...)
+	}
+	return topHints(hs, k)
+}
+
+// QueryHints returns the hints scraped for query q in the country storefront, highest priority first.
+// returns false if q wasn't scraped in the country or itunes had no hints for it.
+func (it *IndexTree) QueryHints(q, country string) ([]Hint, bool) {
+	node, ok := FindNode(it, []byte(q))
+	if !ok {
+		return nil, false
+	}
+	hs, ok := node.hints[country]
+	if !ok {
+		return nil, false
+	}
+	return append([]Hint(nil), hs...), true
+}
+
+// CompleteCountry is Complete over the hints scraped in the country storefront only
+func (it *IndexTree) CompleteCountry(prefix, country string, k int) []Hint {
+	node := findPrefix(it, []byte(prefix))
+	if node == nil {
+		return nil
+	}
+
+	top := node.tops[country]
+	if len(top) > k {
+		top = top[:k]
+	}
+	return append([]Hint(nil), top...)
+}
+
+// returns the shallowest node which query starts with q, or nil if there is no such query
+func findPrefix(it *IndexTree, q []byte) *IndexTree {
+	for len(q) > 0 {
+		_, child := it.child(q[0])
+		if child == nil {
+			return nil
+		}
+		if len(q) <= len(child.label) {
+			if !bytes.HasPrefix(child.label, q) {
+				return nil
+			}
+			return child
+		}
+		if !bytes.HasPrefix(q, child.label) {
+			return nil
+		}
+
+		it = child
+		q = q[len(child.label):]
+	}
+	return it
+}
@@ -0,0 +1,282 @@
+// Package diff compares two hints snapshots, e.g. the crawls of different dates.
+package diff
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/Loofort/xscrape/hints"
+)
+
+// the change statuses
+const (
+	New     = "new"
+	Gone    = "gone"
+	Changed = "changed"
+	Same    = "same"
+)
+
+// Change is the difference of the (query, term) hint between snapshots
+type Change struct {
+	Status  string
+	Query   string
+	Term    string
+	Country string
+	Old     int16
+	New     int16
+}
+
+// Delta is the priority change, the gone hint loses its old priority
+func (ch Change) Delta() int {
+	return int(ch.New) - int(ch.Old)
+}
+
+func (ch Change) String() string {
+	delta := strconv.Itoa(ch.Delta())
+	line := delta + "\t" + ch.Status + "\t" + ch.Query + "\t" + ch.Term
+	if ch.Country != "" {
+		line += "\t" + ch.Country
+	}
+	return line
+}
+
+// Summary aggregates the changes of the term over all its queries
+type Summary struct {
+	Status  string
+	Term    string
+	Country string
+	New     int
+	Gone    int
+	Changed int
+	Same    int
+	Delta   int
+}
+
+// the status is new or gone if the term isn't in the one of snapshots at all
+func (sm Summary) String() string {
+	counts := strconv.Itoa(sm.New) + "/" + strconv.Itoa(sm.Gone) + "/" + strconv.Itoa(sm.Changed) + "/" + strconv.Itoa(sm.Same)
+	line := strconv.Itoa(sm.Delta) + "\t" + sm.Status + "\t" + sm.Term + "\t" + counts
+	if sm.Country != "" {
+		line += "\t" + sm.Country
+	}
+	return line
+}
+
+// Diff calls foo for the change of every (query, term) hint of both snapshots in hints.Sort order.
+// The repeated hint of the snapshot is taken with its max priority.
+// The snapshots are sorted to temporary files first, at most chunk lines are kept in memory, see hints.SortLines.
+func Diff(r1, r2 io.Reader, chunk int, tmpdir string, foo func(Change) error) error {
+	f1, err := sortTemp(r1, chunk, tmpdir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f1.Name())
+	defer f1.Close()
+
+	f2, err := sortTemp(r2, chunk, tmpdir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f2.Name())
+	defer f2.Close()
+
+	u1, u2 := newUniqReader(f1), newUniqReader(f2)
+	one, ok1 := u1.Next()
+	two, ok2 := u2.Next()
+	for ok1 || ok2 {
+		var cmp int
+		switch {
+		case !ok1:
+			cmp = 1
+		case !ok2:
+			cmp = -1
+		default:
+			cmp = compare(one, two)
+		}
+
+		var ch Change
+		switch cmp {
+		case -1: // only in the first
+			ch = Change{Status: Gone, Query: one.Query, Term: one.Term, Country: one.Country, Old: one.Priority}
+			one, ok1 = u1.Next()
+		case 1: // only in the second
+			ch = Change{Status: New, Query: two.Query, Term: two.Term, Country: two.Country, New: two.Priority}
+			two, ok2 = u2.Next()
+		default:
+			status := Same
+			if one.Priority != two.Priority {
+				status = Changed
+			}
+			ch = Change{Status: status, Query: one.Query, Term: one.Term, Country: one.Country, Old: one.Priority, New: two.Priority}
+			one, ok1 = u1.Next()
+			two, ok2 = u2.Next()
+		}
+
+		if err := foo(ch); err != nil {
+			return err
+		}
+	}
+
+	if err := u1.Err(); err != nil {
+		return fmt.Errorf("file 1: %w", err)
+	}
+	if err := u2.Err(); err != nil {
+		return fmt.Errorf("file 2: %w", err)
+	}
+	return nil
+}
+
+// returns the temporary file with sorted hints, it's positioned at the start
+func sortTemp(r io.Reader, chunk int, tmpdir string) (*os.File, error) {
+	tmp, err := ioutil.TempFile(tmpdir, "diff")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hints.SortLines(r, tmp, chunk, tmpdir); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// reads the sorted hints, the repeated hint is taken once with its max priority
+type uniqReader struct {
+	r    *hints.Reader
+	next hints.Hint
+	ok   bool
+}
+
+func newUniqReader(r io.Reader) *uniqReader {
+	u := &uniqReader{r: hints.NewReader(r)}
+	if u.ok = u.r.Next(); u.ok {
+		u.next = u.r.Hint()
+	}
+	return u
+}
+
+// Next returns the next hint, false at the end of input or on error
+func (u *uniqReader) Next() (hints.Hint, bool) {
+	if !u.ok {
+		return hints.Hint{}, false
+	}
+
+	hint := u.next
+	for u.ok = u.r.Next(); u.ok; u.ok = u.r.Next() {
+		next := u.r.Hint()
+		if compare(hint, next) != 0 {
+			u.next = next
+			break
+		}
+		if next.Priority > hint.Priority {
+			hint = next
+		}
+	}
+	return hint, true
+}
+
+func (u *uniqReader) Err() error {
+	return u.r.Err()
+}
+
+// Summarizer aggregates the changes passed in Diff order per term
+type Summarizer struct {
+	sm  *Summary
+	foo func(Summary) error
+}
+
+// NewSummarizer calls foo for the summary of every term, Flush should be called after the last change
+func NewSummarizer(foo func(Summary) error) *Summarizer {
+	return &Summarizer{foo: foo}
+}
+
+// Add counts the change, the summary of the previous term is passed to foo
+func (s *Summarizer) Add(ch Change) error {
+	if s.sm != nil && (s.sm.Term != ch.Term || s.sm.Country != ch.Country) {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+	if s.sm == nil {
+		s.sm = &Summary{Term: ch.Term, Country: ch.Country}
+	}
+
+	sm := s.sm
+	switch ch.Status {
+	case New:
+		sm.New++
+	case Gone:
+		sm.Gone++
+	case Changed:
+		sm.Changed++
+	default:
+		sm.Same++
+	}
+	sm.Delta += ch.Delta()
+	return nil
+}
+
+// Flush passes the summary of the last term to foo
+func (s *Summarizer) Flush() error {
+	if s.sm == nil {
+		return nil
+	}
+
+	sm := *s.sm
+	s.sm = nil
+	switch {
+	case sm.Gone == 0 && sm.Changed == 0 && sm.Same == 0:
+		sm.Status = New
+	case sm.New == 0 && sm.Changed == 0 && sm.Same == 0:
+		sm.Status = Gone
+	case sm.New == 0 && sm.Gone == 0 && sm.Changed == 0:
+		sm.Status = Same
+	default:
+		sm.Status = Changed
+	}
+	return s.foo(sm)
+}
+
+// Summarize aggregates the changes returned by Diff per term, in the same order
+func Summarize(chs []Change) []Summary {
+	sms := []Summary{}
+	s := NewSummarizer(func(sm Summary) error {
+		sms = append(sms, sm)
+		return nil
+	})
+	for _, ch := range chs {
+		s.Add(ch)
+	}
+	s.Flush()
+	return sms
+}
+
+// compares hints in hints.Sort order
+func compare(one, two hints.Hint) int {
+	switch {
+	case one.Country != two.Country:
+		return cmpString(one.Country, two.Country)
+	case one.Term != two.Term:
+		return cmpString(one.Term, two.Term)
+	}
+	return cmpString(one.Query, two.Query)
+}
+
+func cmpString(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
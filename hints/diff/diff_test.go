@@ -0,0 +1,87 @@
+package diff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/hints/diff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	old := []hints.Hint{
+		{Priority: 5, Query: "ab", Term: "abc"},
+		{Priority: 3, Query: "abc", Term: "abc"},
+		// the repeated hint is taken with its max priority
+		{Priority: 7, Query: "abc", Term: "abc"},
+		{Priority: 4, Query: "g", Term: "gone"},
+		{Priority: 2, Query: "s", Term: "same"},
+		{Priority: 2, Query: "s", Term: "same", Country: "gb"},
+	}
+	cur := []hints.Hint{
+		{Priority: 2, Query: "s", Term: "same"},
+		{Priority: 9, Query: "abc", Term: "abc"},
+		{Priority: 5, Query: "ab", Term: "abc"},
+		{Priority: 1, Query: "a", Term: "abc"},
+		{Priority: 6, Query: "n", Term: "new"},
+		{Priority: 3, Query: "s", Term: "same", Country: "gb"},
+	}
+
+	// the chunk of 2 lines makes the sort merge its runs
+	chs := []diff.Change{}
+	err := diff.Diff(bytes.NewReader(hints.ToBytes(old)), bytes.NewReader(hints.ToBytes(cur)), 2, "", func(ch diff.Change) error {
+		chs = append(chs, ch)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []diff.Change{
+		{Status: diff.New, Query: "a", Term: "abc", New: 1},
+		{Status: diff.Same, Query: "ab", Term: "abc", Old: 5, New: 5},
+		{Status: diff.Changed, Query: "abc", Term: "abc", Old: 7, New: 9},
+		{Status: diff.Gone, Query: "g", Term: "gone", Old: 4},
+		{Status: diff.New, Query: "n", Term: "new", New: 6},
+		{Status: diff.Same, Query: "s", Term: "same", Old: 2, New: 2},
+		{Status: diff.Changed, Query: "s", Term: "same", Country: "gb", Old: 2, New: 3},
+	}, chs)
+	require.Equal(t, -4, chs[3].Delta())
+
+	require.Equal(t, []diff.Summary{
+		{Status: diff.Changed, Term: "abc", New: 1, Changed: 1, Same: 1, Delta: 3},
+		{Status: diff.Gone, Term: "gone", Gone: 1, Delta: -4},
+		{Status: diff.New, Term: "new", New: 1, Delta: 6},
+		{Status: diff.Same, Term: "same", Same: 1},
+		{Status: diff.Changed, Term: "same", Country: "gb", Changed: 1, Delta: 1},
+	}, diff.Summarize(chs))
+
+	err = diff.Diff(bytes.NewReader(hints.ToBytes(old)), bytes.NewBufferString("1\tbad\n"), 0, "", func(diff.Change) error { return nil })
+	require.Error(t, err)
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		status   string
+	}{
+		{"all new", []string{diff.New, diff.New}, diff.New},
+		{"all gone", []string{diff.Gone}, diff.Gone},
+		{"all same", []string{diff.Same, diff.Same}, diff.Same},
+		{"new and gone", []string{diff.New, diff.Gone}, diff.Changed},
+		{"new and same", []string{diff.New, diff.Same}, diff.Changed},
+		{"gone and same", []string{diff.Gone, diff.Same}, diff.Changed},
+		{"changed", []string{diff.Changed}, diff.Changed},
+	}
+
+	for _, tt := range tests {
+		chs := []diff.Change{}
+		for _, status := range tt.statuses {
+			chs = append(chs, diff.Change{Status: status, Term: "t"})
+		}
+		sms := diff.Summarize(chs)
+		require.Len(t, sms, 1, tt.name)
+		require.Equal(t, tt.status, sms[0].Status, tt.name)
+	}
+
+	require.Empty(t, diff.Summarize(nil))
+}
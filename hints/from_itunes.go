@@ -1,12 +1,18 @@
 package hints
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
+
+	"github.com/Loofort/xscrape/itunes"
 )
 
 const ihost = "https://search.itunes.apple.com/"
@@ -14,19 +20,34 @@ const ihost = "https://search.itunes.apple.com/"
 var re = regexp.MustCompile(`\r?\n`)
 
 // Scrapes hints from itunes for given query
-func Scrape(q string, client *http.Client) ([]Hint, error) {
+func Scrape(q string, cfg itunes.Config) ([]Hint, error) {
+	return ScrapeContext(context.Background(), q, cfg)
+}
+
+// the request is cancelled when ctx is done
+func ScrapeContext(ctx context.Context, q string, cfg itunes.Config) ([]Hint, error) {
 	// https://search.itunes.apple.com/WebObjects/MZSearchHints.woa/wa/hints?media=software&q=qwe
 	v := url.Values{}
 	v.Set("media", "software")
 	v.Set("q", q)
-	url := ihost + "/WebObjects/MZSearchHints.woa/wa/hints?" + v.Encode()
+	if cfg.Country != "" {
+		v.Set("cc", cfg.Country)
+	}
+	if cfg.Lang != "" {
+		v.Set("l", cfg.Lang)
+	}
+	url := cfg.URL(ihost, "/WebObjects/MZSearchHints.woa/wa/hints", v)
 
-	resp, err := client.Get(url)
+	resp, err := cfg.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, itunes.NewStatusError(resp)
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -34,17 +55,30 @@ func Scrape(q string, client *http.Client) ([]Hint, error) {
 
 	sh := serp{}
 	if err := xml.Unmarshal(body, &sh); err != nil {
+		cfg.Uncache(url)
+		cfg.Limiter.Slowdown()
 		body = re.ReplaceAll(body, []byte("\\n"))
-		return nil, fmt.Errorf("%v: %s", err, body)
+		return nil, fmt.Errorf("%w: %v: %s", itunes.ErrEnvelope, err, body)
 	}
 
 	hints, err := sh.GetHints(q)
 	if err != nil {
+		cfg.Uncache(url)
 		// <html><body><b>Http/1.1 Service Unavailable</b></body> </html>
+		if bytes.Contains(body, []byte("Service Unavailable")) {
+			err = itunes.ErrUnavailable
+		}
+		if !errors.Is(err, itunes.ErrSchema) {
+			cfg.Limiter.Slowdown()
+		}
 		body = re.ReplaceAll(body, []byte("\\n"))
-		return nil, fmt.Errorf("%v: %s", err, body)
+		return nil, fmt.Errorf("%w: %s", err, body)
 	}
+	cfg.Limiter.Recover()
 
+	for i := range hints {
+		hints[i].Country = cfg.Country
+	}
 	return hints, nil
 }
 
@@ -62,7 +96,7 @@ func (sh serp) GetHints(q string) ([]Hint, error) {
 	// check valid format
 	if len(sh.Key) != 2 || len(sh.String) != 1 ||
 		sh.Key[0] != "title" || sh.Key[1] != "hints" || sh.String[0] != "Suggestions" {
-		return nil, fmt.Errorf("invalid xml envelop")
+		return nil, fmt.Errorf("%w: invalid xml envelop", itunes.ErrEnvelope)
 	}
 
 	hints := make([]Hint, 0, len(sh.Dict))
@@ -70,8 +104,8 @@ func (sh serp) GetHints(q string) ([]Hint, error) {
 		// check valid format
 		if len(dict.Key) != 3 || len(dict.String) != 2 || len(dict.Integer) != 1 ||
 			dict.Key[0] != "term" || dict.Key[1] != "priority" || dict.Key[2] != "url" ||
-			dict.String[1][0:len(ihost)] != ihost {
-			return nil, fmt.Errorf("invalid xml dict %d", i)
+			!strings.HasPrefix(dict.String[1], ihost) {
+			return nil, fmt.Errorf("%w: invalid xml dict %d", itunes.ErrSchema, i)
 		}
 
 		hint := Hint{
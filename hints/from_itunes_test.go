@@ -0,0 +1,49 @@
+package hints_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/itunestest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrape(t *testing.T) {
+	s := itunestest.NewServer()
+	defer s.Close()
+	s.Delay = time.Minute
+
+	cases := []struct {
+		sc  itunestest.Scenario
+		err error
+		n   int
+	}{
+		{itunestest.OK, nil, 3}, // the long query gets 3 hints
+		{itunestest.TooMany, nil, 51},
+		{itunestest.Unavailable, itunes.ErrUnavailable, 0},
+		{itunestest.UnavailablePage, itunes.ErrUnavailable, 0},
+		{itunestest.Malformed, itunes.ErrEnvelope, 0},
+		{itunestest.UnknownField, itunes.ErrSchema, 0},
+		{itunestest.Slow, context.DeadlineExceeded, 0},
+	}
+
+	for _, c := range cases {
+		q := "q" + c.sc.String()
+		s.Script(q, c.sc)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		hs, err := hints.ScrapeContext(ctx, q, s.Config())
+		cancel()
+
+		if c.err == nil {
+			require.NoError(t, err, c.sc.String())
+		} else {
+			require.True(t, errors.Is(err, c.err), "%s: %v", c.sc, err)
+		}
+		require.Len(t, hs, c.n, c.sc.String())
+	}
+}
@@ -8,17 +8,25 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/Loofort/xscrape/iostuff"
 )
 
 type Hint struct {
 	Priority int16
 	Query    string
 	Term     string
+	Country  string
 }
 
+// the country is the optional last column, it's omitted for the default storefront
 func (hint Hint) String() string {
 	priority := strconv.Itoa(int(hint.Priority))
-	return priority + "\t" + hint.Query + "\t" + hint.Term
+	line := priority + "\t" + hint.Query + "\t" + hint.Term
+	if hint.Country != "" {
+		line += "\t" + hint.Country
+	}
+	return line
 }
 
 func ToBytes(hints []Hint) []byte {
@@ -31,6 +39,9 @@ func ToBytes(hints []Hint) []byte {
 
 func Sort(hs []Hint) {
 	sort.Slice(hs, func(i, j int) bool {
+		if hs[i].Country != hs[j].Country {
+			return hs[i].Country < hs[j].Country
+		}
 		if hs[i].Term == hs[j].Term {
 			return hs[i].Query < hs[j].Query
 		}
@@ -39,32 +50,120 @@ func Sort(hs []Hint) {
 }
 
 func FromReader(reader io.Reader) ([]Hint, error) {
-	scanner := bufio.NewScanner(reader)
 	hs := []Hint{}
-	for scanner.Scan() {
-		line := scanner.Text()
+	r := NewReader(reader)
+	for r.Next() {
+		hs = append(hs, r.Hint())
+	}
 
-		pices := strings.SplitN(line, "\t", 3)
-		if len(pices) != 3 {
-			return nil, fmt.Errorf("incorrect line: %s", line)
-		}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
 
-		priority, err := strconv.Atoi(pices[0])
-		if err != nil {
-			return nil, fmt.Errorf("bad priority: %v", err)
-		}
+	return hs, nil
+}
 
-		hint := Hint{
-			Priority: int16(priority),
-			Query:    pices[1],
-			Term:     pices[2],
+// Reader reads hints file line by line in constant memory.
+//
+//	r := NewReader(f)
+//	for r.Next() {
+//		hint := r.Hint()
+//	}
+//	err := r.Err()
+type Reader struct {
+	scanner *bufio.Scanner
+	hint    Hint
+	line    int
+	skipped int
+	err     error
+
+	// SkipBad makes Next skip the malformed lines instead of failing, they're counted by Skipped
+	SkipBad bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+// Next reads the next hint, it returns false at the end of input or on error
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	for r.scanner.Scan() {
+		r.line++
+		hint, err := ParseLine(r.scanner.Text())
+		if err == nil {
+			r.hint = hint
+			return true
+		}
+		if !r.SkipBad {
+			r.err = &iostuff.LineError{Line: r.line, Err: err}
+			return false
 		}
-		hs = append(hs, hint)
+		r.skipped++
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if err := r.scanner.Err(); err != nil {
+		r.err = &iostuff.LineError{Line: r.line + 1, Err: err}
+	}
+	return false
+}
+
+// Hint returns the hint read by the last Next
+func (r *Reader) Hint() Hint {
+	return r.hint
+}
+
+// Line returns the number of the last read line
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Skipped returns the number of the malformed lines skipped so far
+func (r *Reader) Skipped() int {
+	return r.skipped
+}
+
+// Err returns the first error, it's *iostuff.LineError with the line number
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// ParseLine parses the hint produced by Hint.String
+func ParseLine(line string) (Hint, error) {
+	pices := strings.SplitN(line, "\t", 4)
+	if len(pices) < 3 {
+		return Hint{}, fmt.Errorf("incorrect line: %s", line)
 	}
 
-	return hs, nil
+	priority, err := strconv.Atoi(pices[0])
+	if err != nil {
+		return Hint{}, fmt.Errorf("bad priority: %v", err)
+	}
+
+	hint := Hint{
+		Priority: int16(priority),
+		Query:    pices[1],
+		Term:     pices[2],
+	}
+	if len(pices) == 4 {
+		hint.Country = pices[3]
+	}
+	return hint, nil
+}
+
+// SortKey returns the key of the hint line, the lines ordered by key are in Sort order
+func SortKey(line string) (string, error) {
+	hint, err := ParseLine(line)
+	if err != nil {
+		return "", err
+	}
+	return hint.Country + "\x00" + hint.Term + "\x00" + hint.Query, nil
+}
+
+// SortLines sorts hints file of any size in Sort order, at most chunk lines are kept in memory.
+func SortLines(r io.Reader, w io.Writer, chunk int, tmpdir string) error {
+	return iostuff.SortLines(r, w, SortKey, chunk, tmpdir)
 }
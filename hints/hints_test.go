@@ -0,0 +1,48 @@
+package hints
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Loofort/xscrape/iostuff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	input := "5\ta\tab\nbad line\n3\ta\tac\tgb\nx\ta\tad\n"
+
+	_, err := FromReader(strings.NewReader(input))
+	var lerr *iostuff.LineError
+	require.True(t, errors.As(err, &lerr), "%v", err)
+	require.Equal(t, 2, lerr.Line)
+
+	r := NewReader(strings.NewReader(input))
+	r.SkipBad = true
+	hs := []Hint{}
+	for r.Next() {
+		hs = append(hs, r.Hint())
+	}
+	require.NoError(t, r.Err())
+	require.Equal(t, []Hint{{5, "a", "ab", ""}, {3, "a", "ac", "gb"}}, hs)
+	require.Equal(t, 2, r.Skipped())
+	require.Equal(t, 4, r.Line())
+}
+
+func TestMerge(t *testing.T) {
+	old := "5\ta\tab\n7\ta\tac\n"
+	cur := "9\ta\tab\n3\ta\tac\n1\tb\tbx\tgb"
+
+	expected := map[string]string{
+		MergeLatest:  "9\ta\tab\n3\ta\tac\n1\tb\tbx\tgb\n",
+		MergeMax:     "9\ta\tab\n7\ta\tac\n1\tb\tbx\tgb\n",
+		MergeHistory: "5\ta\tab\n9\ta\tab\n7\ta\tac\n3\ta\tac\n1\tb\tbx\tgb\n",
+	}
+	for policy, exp := range expected {
+		w := new(strings.Builder)
+		rs := []io.Reader{strings.NewReader(old), strings.NewReader(cur)}
+		require.NoError(t, Merge(rs, w, policy, 2, ""))
+		require.Equal(t, exp, w.String(), policy)
+	}
+}
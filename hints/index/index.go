@@ -0,0 +1,394 @@
+// Package index keeps the sorted query index of hints file in the sidecar file.
+// The index maps every query to the offsets of its hints groups in the hints file.
+// Queries are front coded in blocks, so the index is a few bytes per query
+// and it's memory mapped instead of being loaded.
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Loofort/xscrape/hints"
+)
+
+// Ext is appended to the hints file name to get the index file name
+const Ext = ".hidx"
+
+const (
+	magic      = "HIDX0001"
+	headerSize = 24
+	blockSize  = 16
+)
+
+type entry struct {
+	query  string
+	offset int64
+}
+
+// Build reads hints file and writes the index next to it.
+// The group is the run of lines with the same query and country.
+func Build(hintsFile string) error {
+	f, err := os.Open(hintsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := []entry{}
+	var query, country string
+	offset := int64(0)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" {
+			break
+		}
+
+		hint, perr := hints.ParseLine(strings.TrimSuffix(line, "\n"))
+		if perr != nil {
+			return fmt.Errorf("offset %d: %v", offset, perr)
+		}
+		if offset == 0 || hint.Query != query || hint.Country != country {
+			entries = append(entries, entry{hint.Query, offset})
+			query, country = hint.Query, hint.Country
+		}
+		offset += int64(len(line))
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].query == entries[j].query {
+			return entries[i].offset < entries[j].offset
+		}
+		return entries[i].query < entries[j].query
+	})
+
+	// write to temp file, so the broken index never replaces the good one
+	tmp := hintsFile + Ext + ".tmp"
+	if err := write(tmp, offset, entries); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, hintsFile+Ext)
+}
+
+// layout: header, block offsets, blocks.
+// the entry is: shared prefix len, suffix len, suffix, hints offset; all numbers are uvarints.
+// the first entry of the block has the whole query.
+func write(filename string, size int64, entries []entry) error {
+	nblocks := (len(entries) + blockSize - 1) / blockSize
+	table := make([]byte, 8*nblocks)
+	body := new(bytes.Buffer)
+	num := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(x uint64) {
+		n := binary.PutUvarint(num, x)
+		body.Write(num[:n])
+	}
+
+	prev := ""
+	for i, e := range entries {
+		if i%blockSize == 0 {
+			binary.LittleEndian.PutUint64(table[8*(i/blockSize):], uint64(body.Len()))
+			prev = ""
+		}
+
+		shared := 0
+		for shared < len(prev) && shared < len(e.query) && prev[shared] == e.query[shared] {
+			shared++
+		}
+		putUvarint(uint64(shared))
+		putUvarint(uint64(len(e.query) - shared))
+		body.WriteString(e.query[shared:])
+		putUvarint(uint64(e.offset))
+		prev = e.query
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.LittleEndian.PutUint64(header[8:], uint64(size))
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(entries)))
+	binary.LittleEndian.PutUint32(header[20:], uint32(nblocks))
+
+	f, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, b := range [][]byte{header, table, body.Bytes()} {
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Index is the memory mapped index opened along with its hints file
+type Index struct {
+	hf      *os.File
+	size    int64
+	count   int
+	nblocks int
+	table   []byte
+	body    []byte
+	unmap   func() error
+}
+
+// Open maps the index of hints file.
+// returns error if the index is missing or the hints file was changed after Build.
+func Open(hintsFile string) (*Index, error) {
+	f, err := os.Open(hintsFile + Ext)
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mmap(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	ix, err := open(hintsFile, data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	ix.unmap = unmap
+	return ix, nil
+}
+
+func open(hintsFile string, data []byte) (*Index, error) {
+	if len(data) < headerSize || string(data[:8]) != magic {
+		return nil, fmt.Errorf("invalid index file %s%s", hintsFile, Ext)
+	}
+	size := int64(binary.LittleEndian.Uint64(data[8:]))
+	count := int(binary.LittleEndian.Uint32(data[16:]))
+	nblocks := int(binary.LittleEndian.Uint32(data[20:]))
+	if len(data) < headerSize+8*nblocks {
+		return nil, fmt.Errorf("truncated index file %s%s", hintsFile, Ext)
+	}
+	if nblocks != (count+blockSize-1)/blockSize {
+		return nil, fmt.Errorf("invalid index file %s%s: %d entries in %d blocks", hintsFile, Ext, count, nblocks)
+	}
+
+	hf, err := os.Open(hintsFile)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := hf.Stat()
+	if err != nil {
+		hf.Close()
+		return nil, err
+	}
+	if stat.Size() != size {
+		hf.Close()
+		return nil, fmt.Errorf("index is stale: hints file size %d, indexed %d", stat.Size(), size)
+	}
+
+	ix := &Index{
+		hf:      hf,
+		size:    size,
+		count:   count,
+		nblocks: nblocks,
+		table:   data[headerSize : headerSize+8*nblocks],
+		body:    data[headerSize+8*nblocks:],
+	}
+	return ix, nil
+}
+
+func (ix *Index) Close() error {
+	err := ix.hf.Close()
+	if uerr := ix.unmap(); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// Len returns the number of indexed hints groups
+func (ix *Index) Len() int {
+	return ix.count
+}
+
+// Hints returns all the hints scraped for query q
+func (ix *Index) Hints(q string) ([]hints.Hint, error) {
+	offsets := []int64{}
+	err := ix.scan(q, func(key []byte, offset int64) bool {
+		if string(key) != q {
+			return false
+		}
+		offsets = append(offsets, offset)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hs := []hints.Hint{}
+	for _, offset := range offsets {
+		group, err := ix.group(offset)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, group...)
+	}
+	return hs, nil
+}
+
+// Queries returns all the scraped queries starting with prefix in sorted order
+func (ix *Index) Queries(prefix string) ([]string, error) {
+	qs := []string{}
+	err := ix.scan(prefix, func(key []byte, offset int64) bool {
+		if !bytes.HasPrefix(key, []byte(prefix)) {
+			return false
+		}
+		if len(qs) == 0 || qs[len(qs)-1] != string(key) {
+			qs = append(qs, string(key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return qs, nil
+}
+
+// reads the hints group starting at offset
+func (ix *Index) group(offset int64) ([]hints.Hint, error) {
+	r := bufio.NewReader(io.NewSectionReader(ix.hf, offset, ix.size-offset))
+	hs := []hints.Hint{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" {
+			return hs, nil
+		}
+
+		hint, perr := hints.ParseLine(strings.TrimSuffix(line, "\n"))
+		if perr != nil {
+			return nil, fmt.Errorf("offset %d: %v", offset, perr)
+		}
+		if len(hs) > 0 && (hint.Query != hs[0].Query || hint.Country != hs[0].Country) {
+			return hs, nil
+		}
+		hs = append(hs, hint)
+
+		if err == io.EOF {
+			return hs, nil
+		}
+	}
+}
+
+// calls foo for the entries starting from the first key >= from, until foo returns false
+func (ix *Index) scan(from string, foo func(key []byte, offset int64) bool) error {
+	// the first block which could hold the key
+	var err error
+	i := sort.Search(ix.nblocks, func(i int) bool {
+		key, kerr := ix.firstKey(i)
+		if kerr != nil {
+			err = kerr
+			return true
+		}
+		return string(key) >= from
+	})
+	if err != nil {
+		return err
+	}
+	if i > 0 {
+		i--
+	}
+
+	key := []byte{}
+	for ; i < ix.nblocks; i++ {
+		buf, err := ix.block(i)
+		if err != nil {
+			return err
+		}
+		n := ix.count - i*blockSize
+		if n > blockSize {
+			n = blockSize
+		}
+
+		key = key[:0]
+		for j := 0; j < n; j++ {
+			var offset int64
+			key, offset, buf, err = decode(key, buf)
+			if err != nil {
+				return fmt.Errorf("block %d: %v", i, err)
+			}
+			if offset >= ix.size {
+				return fmt.Errorf("block %d: hints offset %d is out of hints file", i, offset)
+			}
+			if string(key) < from {
+				continue
+			}
+			if !foo(key, offset) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (ix *Index) block(i int) ([]byte, error) {
+	ofs := binary.LittleEndian.Uint64(ix.table[8*i:])
+	if ofs > uint64(len(ix.body)) {
+		return nil, fmt.Errorf("block %d: offset %d is out of index", i, ofs)
+	}
+	return ix.body[ofs:], nil
+}
+
+func (ix *Index) firstKey(i int) ([]byte, error) {
+	buf, err := ix.block(i)
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, err := decode(nil, buf)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %v", i, err)
+	}
+	return key, nil
+}
+
+var errCorrupt = errors.New("corrupt index entry")
+
+// decodes the entry on top of the previous key, returns the rest of buf.
+// the numbers read from disk are checked, so the corrupt index is the error rather than panic.
+func decode(prev, buf []byte) ([]byte, int64, []byte, error) {
+	shared, n := binary.Uvarint(buf)
+	if n <= 0 || shared > uint64(len(prev)) {
+		return nil, 0, nil, errCorrupt
+	}
+	buf = buf[n:]
+	suffix, n := binary.Uvarint(buf)
+	if n <= 0 || suffix > uint64(len(buf)-n) {
+		return nil, 0, nil, errCorrupt
+	}
+	buf = buf[n:]
+
+	key := append(prev[:shared:shared], buf[:suffix]...)
+	buf = buf[suffix:]
+
+	offset, n := binary.Uvarint(buf)
+	if n <= 0 || offset > math.MaxInt64 {
+		return nil, 0, nil, errCorrupt
+	}
+	return key, int64(offset), buf[n:], nil
+}
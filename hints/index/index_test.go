@@ -0,0 +1,141 @@
+package index_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/hints/index"
+	"github.com/stretchr/testify/require"
+)
+
+// writes the hints of 40 queries, so the index takes 3 blocks,
+// the query "q05" is scraped twice, in us and gb storefronts
+func writeHints(t *testing.T) (string, map[string][]hints.Hint) {
+	dir, err := ioutil.TempDir("", "index")
+	require.NoError(t, err)
+
+	hs := []hints.Hint{}
+	byQuery := map[string][]hints.Hint{}
+	add := func(h hints.Hint) {
+		hs = append(hs, h)
+		byQuery[h.Query] = append(byQuery[h.Query], h)
+	}
+	for i := 39; i >= 0; i-- {
+		q := fmt.Sprintf("q%02d", i)
+		add(hints.Hint{Priority: 2, Query: q, Term: q + " a", Country: "us"})
+		add(hints.Hint{Priority: 1, Query: q, Term: q + " b", Country: "us"})
+	}
+	add(hints.Hint{Priority: 3, Query: "q05", Term: "q05 c", Country: "gb"})
+
+	filename := filepath.Join(dir, "hints.txt")
+	require.NoError(t, ioutil.WriteFile(filename, hints.ToBytes(hs), 0644))
+	return filename, byQuery
+}
+
+func TestIndex(t *testing.T) {
+	filename, byQuery := writeHints(t)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	require.NoError(t, index.Build(filename))
+	ix, err := index.Open(filename)
+	require.NoError(t, err)
+	defer ix.Close()
+
+	require.Equal(t, 41, ix.Len())
+
+	// the first, the last and the block boundary queries
+	for _, q := range []string{"q00", "q05", "q15", "q16", "q31", "q32", "q39"} {
+		hs, err := ix.Hints(q)
+		require.NoError(t, err)
+		require.Equal(t, byQuery[q], hs, q)
+	}
+	hs, err := ix.Hints("q40")
+	require.NoError(t, err)
+	require.Empty(t, hs)
+
+	qs, err := ix.Queries("q1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"q10", "q11", "q12", "q13", "q14", "q15", "q16", "q17", "q18", "q19"}, qs)
+
+	qs, err = ix.Queries("")
+	require.NoError(t, err)
+	require.Len(t, qs, 40)
+
+	qs, err = ix.Queries("x")
+	require.NoError(t, err)
+	require.Empty(t, qs)
+}
+
+func TestIndexStale(t *testing.T) {
+	filename, _ := writeHints(t)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	_, err := index.Open(filename)
+	require.Error(t, err)
+
+	require.NoError(t, index.Build(filename))
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("1\tq50\tq50 a\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = index.Open(filename)
+	require.Error(t, err)
+}
+
+func TestIndexCorrupt(t *testing.T) {
+	filename, _ := writeHints(t)
+	defer os.RemoveAll(filepath.Dir(filename))
+	require.NoError(t, index.Build(filename))
+
+	data, err := ioutil.ReadFile(filename + index.Ext)
+	require.NoError(t, err)
+
+	// header and 3 block offsets
+	body := 24 + 8*3
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{
+			name: "truncated blocks",
+			corrupt: func(data []byte) []byte {
+				return data[:body+10]
+			},
+		},
+		{
+			name: "garbage blocks",
+			corrupt: func(data []byte) []byte {
+				for i := body; i < len(data); i++ {
+					data[i] = 0xff
+				}
+				return data
+			},
+		},
+		{
+			name: "block offset out of index",
+			corrupt: func(data []byte) []byte {
+				data[body-1] = 0xff
+				return data
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		bad := tt.corrupt(append([]byte{}, data...))
+		require.NoError(t, ioutil.WriteFile(filename+index.Ext, bad, 0644), tt.name)
+
+		ix, err := index.Open(filename)
+		require.NoError(t, err, tt.name)
+		_, err = ix.Hints("q39")
+		require.Error(t, err, tt.name)
+		_, err = ix.Queries("q")
+		require.Error(t, err, tt.name)
+		require.NoError(t, ix.Close(), tt.name)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !linux && !freebsd && !netbsd && !openbsd
+// +build !darwin,!linux,!freebsd,!netbsd,!openbsd
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// no mmap, the index is read into memory
+func mmap(f *os.File) ([]byte, func() error, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -6,22 +6,65 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 )
 
+// IndexTree is the path compressed radix tree of the queries.
+// The node keeps only its part of the query and the children sorted by the first byte.
 type IndexTree struct {
-	name   []byte
+	label  []byte
+	parent *IndexTree
 	depth  int
 	offset int64
-	childs [256]*IndexTree
+	added  bool
+	childs []*IndexTree
+
+	// own hints and top hints of the subtree per storefront country, filled by NewCompleteIndex
+	hints map[string][]Hint
+	tops  map[string][]Hint
+
+	// hints offsets of the query per storefront country
+	countries map[string]int64
 }
 
 func (it *IndexTree) IsLeaf() bool {
-	for _, node := range it.childs {
-		if node != nil {
-			return false
-		}
+	return len(it.childs) == 0
+}
+
+// Name returns the full query of the node
+func (it *IndexTree) Name() []byte {
+	name := make([]byte, it.depth)
+	for node := it; node.parent != nil; node = node.parent {
+		copy(name[node.depth-len(node.label):], node.label)
 	}
-	return true
+	return name
+}
+
+func (it *IndexTree) Depth() int {
+	return it.depth
+}
+
+// Offset returns the hints offset of the query, it's zero for the branch node that wasn't added.
+// The query scraped in several countries has the offset of the first one.
+func (it *IndexTree) Offset() int64 {
+	return it.offset
+}
+
+// CountryOffset returns the hints offset of the query scraped in the country storefront
+func (it *IndexTree) CountryOffset(country string) (int64, bool) {
+	offset, ok := it.countries[country]
+	return offset, ok
+}
+
+// returns the child which label starts with char and its position
+func (it *IndexTree) child(char byte) (int, *IndexTree) {
+	i := sort.Search(len(it.childs), func(i int) bool {
+		return it.childs[i].label[0] >= char
+	})
+	if i < len(it.childs) && it.childs[i].label[0] == char {
+		return i, it.childs[i]
+	}
+	return i, nil
 }
 
 func NewIndexFromFile(hintsFile string) (*IndexTree, error) {
@@ -33,7 +76,7 @@ func NewIndexFromFile(hintsFile string) (*IndexTree, error) {
 	}
 	defer f.Close()
 
-	var query []byte
+	var query, country []byte
 	offset := int64(0)
 	r := bufio.NewReader(f)
 	for {
@@ -47,67 +90,121 @@ func NewIndexFromFile(hintsFile string) (*IndexTree, error) {
 
 		offset += int64(len(line))
 
-		pices := bytes.SplitN(line, []byte{'\t'}, 3)
-		if len(pices) != 3 {
+		pices := bytes.SplitN(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\t'}, 4)
+		if len(pices) < 3 {
 			return nil, fmt.Errorf("incorrect line: %s", line)
 		}
-		if bytes.Compare(query, pices[1]) == 0 {
+		var cc []byte
+		if len(pices) == 4 {
+			cc = pices[3]
+		}
+		if bytes.Equal(query, pices[1]) && bytes.Equal(country, cc) {
 			continue
 		}
 
-		// find new query
-		query = pices[1]
-		ofs := offset - int64(len(line))
-		if AddNode(root, query, ofs) == nil {
-			return nil, fmt.Errorf("unable to add node %s offset %d", query, ofs)
-		}
+		// find new group, the group repeated in the file is skipped
+		query, country = pices[1], cc
+		AddCountryNode(root, query, string(country), offset-int64(len(line)))
 	}
 
 	return root, nil
 }
 
+// FindNode returns the node of q and true if it exists,
+// otherwise the deepest node which query is the prefix of q and false.
 func FindNode(it *IndexTree, q []byte) (*IndexTree, bool) {
-	for _, char := range q {
-		child := it.childs[char]
-		if child == nil {
+	for len(q) > 0 {
+		_, child := it.child(q[0])
+		if child == nil || !bytes.HasPrefix(q, child.label) {
 			return it, false
 		}
 
 		it = child
+		q = q[len(child.label):]
 	}
 	return it, true
 }
 
+// AddNode adds query q with its hints offset.
+// returns nil if q was already added.
 func AddNode(it *IndexTree, q []byte, offset int64) *IndexTree {
-	node, ok := FindNode(it, q)
-	if ok {
-		// node already exists
+	node := insert(it, q)
+	if node.added {
+		return nil
+	}
+	node.added = true
+	node.offset = offset
+	return node
+}
+
+// AddCountryNode adds the hints offset of query q scraped in the country storefront.
+// returns nil if q was already added with the country.
+func AddCountryNode(it *IndexTree, q []byte, country string, offset int64) *IndexTree {
+	node := insert(it, q)
+	if _, ok := node.countries[country]; ok {
 		return nil
 	}
+	if node.countries == nil {
+		node.countries = map[string]int64{}
+	}
+	node.countries[country] = offset
 
-	d := node.depth
-	for i, char := range q[d:] {
-		child := &IndexTree{
-			name:  q[:d+i+1],
-			depth: d + i + 1,
+	if !node.added {
+		node.added = true
+		node.offset = offset
+	}
+	return node
+}
+
+// returns the node of q, the missing nodes are created
+func insert(it *IndexTree, q []byte) *IndexTree {
+	node := it
+	for len(q) > 0 {
+		i, child := node.child(q[0])
+		if child == nil {
+			leaf := &IndexTree{
+				label:  append([]byte(nil), q...),
+				parent: node,
+				depth:  node.depth + len(q),
+			}
+			node.childs = append(node.childs, nil)
+			copy(node.childs[i+1:], node.childs[i:])
+			node.childs[i] = leaf
+			node = leaf
+			break
+		}
+
+		common := 0
+		for common < len(child.label) && common < len(q) && child.label[common] == q[common] {
+			common++
+		}
+		if common < len(child.label) {
+			// split the edge
+			branch := &IndexTree{
+				label:  append([]byte(nil), child.label[:common]...),
+				parent: node,
+				depth:  node.depth + common,
+				childs: []*IndexTree{child},
+			}
+			child.label = append([]byte(nil), child.label[common:]...)
+			child.parent = branch
+			node.childs[i] = branch
+			child = branch
 		}
 
-		node.childs[char] = child
 		node = child
+		q = q[common:]
 	}
-
-	node.offset = offset
 	return node
 }
 
+// WalkTree calls foo for every descendant of the node in breadth first order
 func WalkTree(node *IndexTree, foo func(*IndexTree)) {
-	nodes := node.childs[:]
+	nodes := append([]*IndexTree(nil), node.childs...)
 	for len(nodes) > 0 {
 		node, nodes = nodes[0], nodes[1:]
-		if node != nil {
-			foo(node)
-			nodes = append(nodes, node.childs[:]...)
-		}
+		foo(node)
+		nodes = append(nodes, node.childs...)
 	}
 }
 
@@ -135,9 +232,9 @@ func leafIndex(hintsFile string) {
 				check(err)
 
 				pices := bytes.SplitN(line, []byte{'\t'}, 3)
-				if bytes.Compare(node.name, pices[1]) != 0 {
+				if bytes.Compare(node.Name(), pices[1]) != 0 {
 					if i == 0 {
-						log.Printf("node.name %s ,pices[1] %s\n", node.name, pices[1])
+						log.Printf("node.name %s ,pices[1] %s\n", node.Name(), pices[1])
 					}
 					return
 				}
@@ -0,0 +1,288 @@
+package hints
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const letters = "abcdefghijklmnopqrstuvwxyz0123456789."
+
+// arrayTree is the former 256-slot IndexTree, it's kept to compare with
+type arrayTree struct {
+	name   []byte
+	depth  int
+	offset int64
+	childs [256]*arrayTree
+}
+
+func findArrayNode(it *arrayTree, q []byte) (*arrayTree, bool) {
+	for _, char := range q {
+		child := it.childs[char]
+		if child == nil {
+			return it, false
+		}
+		it = child
+	}
+	return it, true
+}
+
+func addArrayNode(it *arrayTree, q []byte, offset int64) *arrayTree {
+	node, ok := findArrayNode(it, q)
+	if ok {
+		return nil
+	}
+
+	d := node.depth
+	for i, char := range q[d:] {
+		child := &arrayTree{
+			name:  q[:d+i+1],
+			depth: d + i + 1,
+		}
+		node.childs[char] = child
+		node = child
+	}
+	node.offset = offset
+	return node
+}
+
+func newArrayIndexFromFile(hintsFile string) (*arrayTree, error) {
+	root := &arrayTree{}
+	f, err := os.Open(hintsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var query []byte
+	offset := int64(0)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+
+		pices := bytes.SplitN(line, []byte{'\t'}, 3)
+		if bytes.Equal(query, pices[1]) {
+			continue
+		}
+		query = pices[1]
+		if addArrayNode(root, query, offset-int64(len(line))) == nil {
+			return nil, fmt.Errorf("unable to add node %s", query)
+		}
+	}
+	return root, nil
+}
+
+// writes the crawl-like hints file: queries up to depth in breadth first order, 3 hints per query
+func syntheticHints(tb testing.TB, depth int) (string, []string) {
+	dir, err := ioutil.TempDir("", "hints")
+	require.NoError(tb, err)
+	filename := filepath.Join(dir, "hints.txt")
+
+	queries := []string{}
+	level := []string{""}
+	for d := 0; d < depth; d++ {
+		next := []string{}
+		for _, q := range level {
+			for _, b := range letters {
+				next = append(next, q+string(b))
+			}
+		}
+		queries = append(queries, next...)
+		level = next
+	}
+
+	b := new(bytes.Buffer)
+	for _, q := range queries {
+		for p := 3; p > 0; p-- {
+			fmt.Fprintf(b, "%s\n", Hint{Priority: int16(p), Query: q, Term: q + " term"})
+		}
+	}
+	require.NoError(tb, ioutil.WriteFile(filename, b.Bytes(), 0644))
+	return filename, queries
+}
+
+func TestIndexTree(t *testing.T) {
+	filename, queries := syntheticHints(t, 2)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	root, err := NewIndexFromFile(filename)
+	require.NoError(t, err)
+	aroot, err := newArrayIndexFromFile(filename)
+	require.NoError(t, err)
+
+	for _, q := range queries {
+		node, ok := FindNode(root, []byte(q))
+		require.True(t, ok, q)
+		anode, _ := findArrayNode(aroot, []byte(q))
+		require.Equal(t, anode.offset, node.Offset(), q)
+		require.Equal(t, q, string(node.Name()))
+	}
+
+	node, ok := FindNode(root, []byte("ab-"))
+	require.False(t, ok)
+	require.Equal(t, "ab", string(node.Name()))
+
+	require.Nil(t, AddNode(root, []byte("ab"), 0))
+
+	// split the edge
+	tree := &IndexTree{}
+	require.NotNil(t, AddNode(tree, []byte("abcd"), 1))
+	require.NotNil(t, AddNode(tree, []byte("abx"), 2))
+	require.NotNil(t, AddNode(tree, []byte("ab"), 3))
+	node, ok = FindNode(tree, []byte("abc"))
+	require.False(t, ok)
+	require.Equal(t, int64(3), node.Offset())
+	require.False(t, node.IsLeaf())
+
+	names := []string{}
+	WalkTree(tree, func(node *IndexTree) {
+		names = append(names, string(node.Name()))
+	})
+	require.Equal(t, []string{"ab", "abcd", "abx"}, names)
+}
+
+// the crawls of several storefronts are appended to one file
+func TestIndexTreeCountries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "hints.txt")
+
+	b := new(bytes.Buffer)
+	offsets := map[string]int64{}
+	for _, cc := range []string{"", "us", "gb", "de"} {
+		for _, q := range []string{"q0", "q00", "q01"} {
+			offsets[cc+" "+q] = int64(b.Len())
+			for p := 3; p > 0; p-- {
+				fmt.Fprintf(b, "%s\n", Hint{Priority: int16(p), Query: q, Term: q + " term", Country: cc})
+			}
+		}
+	}
+	// the repeated group is skipped
+	fmt.Fprintf(b, "%s\n", Hint{Priority: 1, Query: "q00", Term: "q00 term", Country: "us"})
+	require.NoError(t, ioutil.WriteFile(filename, b.Bytes(), 0644))
+
+	root, err := NewIndexFromFile(filename)
+	require.NoError(t, err)
+
+	for key, offset := range offsets {
+		pices := strings.SplitN(key, " ", 2)
+		node, ok := FindNode(root, []byte(pices[1]))
+		require.True(t, ok, key)
+		ofs, ok := node.CountryOffset(pices[0])
+		require.True(t, ok, key)
+		require.Equal(t, offset, ofs, key)
+		require.Equal(t, offsets[" "+pices[1]], node.Offset(), key)
+	}
+
+	node, _ := FindNode(root, []byte("q0"))
+	_, ok := node.CountryOffset("fr")
+	require.False(t, ok)
+}
+
+func BenchmarkIndexTree(b *testing.B) {
+	filename, _ := syntheticHints(b, 3)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	b.Run("radix", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := NewIndexFromFile(filename)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("array", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := newArrayIndexFromFile(filename)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkFindNode(b *testing.B) {
+	filename, queries := syntheticHints(b, 3)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	root, err := NewIndexFromFile(filename)
+	require.NoError(b, err)
+	aroot, err := newArrayIndexFromFile(filename)
+	require.NoError(b, err)
+
+	b.Run("radix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			FindNode(root, []byte(queries[i%len(queries)]))
+		}
+	})
+
+	b.Run("array", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			findArrayNode(aroot, []byte(queries[i%len(queries)]))
+		}
+	})
+}
+
+func TestComplete(t *testing.T) {
+	hs := []Hint{
+		{Priority: 5, Query: "ab", Term: "abc"},
+		{Priority: 9, Query: "abd", Term: "abd game"},
+		{Priority: 7, Query: "abd", Term: "abc"},
+		{Priority: 1, Query: "abx", Term: "abx"},
+		{Priority: 3, Query: "b", Term: "b"},
+		{Priority: 2, Query: "abcdef", Term: "abcdef"},
+	}
+	it, err := NewCompleteIndex(bytes.NewReader(ToBytes(hs)), 2)
+	require.NoError(t, err)
+
+	terms := func(hs []Hint) []string {
+		ts := []string{}
+		for _, h := range hs {
+			ts = append(ts, h.Term)
+		}
+		return ts
+	}
+
+	require.Equal(t, []string{"abd game", "abc"}, terms(it.Complete("a", 2)))
+	require.Equal(t, int16(7), it.Complete("a", 2)[1].Priority)
+	// the prefix in the middle of edge
+	require.Equal(t, []string{"abcdef"}, terms(it.Complete("abcd", 5)))
+	require.Equal(t, []string{"abd game", "abc"}, terms(it.Complete("abd", 5)))
+	require.Equal(t, []string{"abd game", "abc"}, terms(it.Complete("", 2)))
+	require.Empty(t, it.Complete("abz", 2))
+	require.Empty(t, it.Complete("c", 2))
+}
+
+func TestCompleteCountry(t *testing.T) {
+	hs := []Hint{
+		{Priority: 5, Query: "ab", Term: "abc", Country: "us"},
+		{Priority: 9, Query: "ab", Term: "abc", Country: "gb"},
+		{Priority: 7, Query: "abd", Term: "abd game", Country: "gb"},
+		{Priority: 3, Query: "abx", Term: "abx", Country: "us"},
+	}
+	it, err := NewCompleteIndex(bytes.NewReader(ToBytes(hs)), 2)
+	require.NoError(t, err)
+
+	// one storefront doesn't hide the other's hint
+	require.Equal(t, []Hint{hs[1], hs[2]}, it.Complete("a", 2))
+	require.Equal(t, []Hint{hs[1], hs[2], hs[0]}, it.Complete("ab", 3))
+	require.Equal(t, []Hint{hs[0], hs[3]}, it.CompleteCountry("a", "us", 2))
+	require.Equal(t, []Hint{hs[1], hs[2]}, it.CompleteCountry("a", "gb", 5))
+	require.Empty(t, it.CompleteCountry("a", "de", 2))
+	require.Empty(t, it.CompleteCountry("abx", "gb", 2))
+}
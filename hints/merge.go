@@ -0,0 +1,132 @@
+package hints
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Loofort/xscrape/iostuff"
+)
+
+// Merge policies of the hint found in several files
+const (
+	// the priority of the last file wins
+	MergeLatest = "latest"
+	// the max priority wins
+	MergeMax = "max"
+	// every priority change is kept in the files order
+	MergeHistory = "history"
+)
+
+// Merge combines hints files into one in Sort order.
+// The hint is identified by country, query and term, the files are expected from oldest to newest,
+// the conflicting priorities are resolved by policy.
+// At most chunk lines are kept in memory, see SortLines.
+func Merge(files []io.Reader, w io.Writer, policy string, chunk int, tmpdir string) error {
+	if policy != MergeLatest && policy != MergeMax && policy != MergeHistory {
+		return fmt.Errorf("unknown merge policy %s", policy)
+	}
+
+	tmp, err := ioutil.TempFile(tmpdir, "merge")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// the sort is stable, so the same hints stay in files order
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(concatLines(files, pw))
+	}()
+	if err := SortLines(pr, tmp, chunk, tmpdir); err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	write := func(hint Hint) error {
+		_, err := fmt.Fprintf(bw, "%s\n", hint)
+		return err
+	}
+
+	var group []Hint
+	flush := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		switch policy {
+		case MergeLatest:
+			return write(group[len(group)-1])
+		case MergeMax:
+			best := group[0]
+			for _, hint := range group[1:] {
+				if hint.Priority >= best.Priority {
+					best = hint
+				}
+			}
+			return write(best)
+		}
+
+		// history
+		for i, hint := range group {
+			if i == 0 || hint.Priority != group[i-1].Priority {
+				if err := write(hint); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	r := NewReader(tmp)
+	for r.Next() {
+		hint := r.Hint()
+		if len(group) > 0 {
+			last := group[0]
+			if hint.Country != last.Country || hint.Term != last.Term || hint.Query != last.Query {
+				if err := flush(); err != nil {
+					return err
+				}
+				group = group[:0]
+			}
+		}
+		group = append(group, hint)
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writes the lines of all the readers, the last line gets newline if it's missing.
+// the lines are checked here to report the file and line of the malformed one.
+func concatLines(rs []io.Reader, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i, r := range rs {
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			if _, err := ParseLine(scanner.Text()); err != nil {
+				return fmt.Errorf("file %d: %w", i+1, &iostuff.LineError{Line: line, Err: err})
+			}
+			if _, err := bw.Write(scanner.Bytes()); err != nil {
+				return err
+			}
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
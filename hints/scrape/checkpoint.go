@@ -0,0 +1,283 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Loofort/xscrape/iostuff"
+)
+
+const (
+	queriesFile = "queries"
+	marksFile   = "queries.idx"
+)
+
+// Checkpoint is the Pipe that keeps the crawl state on disk, so the crawl can be resumed after crash.
+// The state directory holds two files:
+//
+//	queries     - every pushed query, one per line, in push order
+//	queries.idx - int16 Analize mark per query line, zero means the query isn't finished yet
+type Checkpoint struct {
+	mux      *sync.Mutex
+	cond     *sync.Cond
+	qf       *os.File
+	mf       *os.File
+	count    int64
+	pending  []string
+	state    map[string]*entry
+	known    map[string]struct{}
+	inflight int
+	closed   bool
+	err      error
+}
+
+type entry struct {
+	idx    int64
+	queued bool
+}
+
+// Opens the checkpoint stored in dir or creates the new one from seed queries.
+// Also returns wait func that returns when the crawl is done or the pipe was closed.
+func NewCheckpoint(dir string, seed []string) (*Checkpoint, func() error, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	qf, err := os.OpenFile(filepath.Join(dir, queriesFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	mf, err := os.OpenFile(filepath.Join(dir, marksFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		qf.Close()
+		return nil, nil, err
+	}
+
+	cp := &Checkpoint{
+		mux:   new(sync.Mutex),
+		qf:    qf,
+		mf:    mf,
+		state: map[string]*entry{},
+		known: map[string]struct{}{},
+	}
+	cp.cond = sync.NewCond(cp.mux)
+
+	if err := cp.load(); err != nil {
+		cp.closeFiles()
+		return nil, nil, err
+	}
+	if cp.count == 0 {
+		cp.Push(seed)
+		if cp.err != nil {
+			cp.closeFiles()
+			return nil, nil, cp.err
+		}
+	}
+
+	wait := func() error {
+		cp.mux.Lock()
+		for cp.inflight > 0 || (len(cp.pending) > 0 && !cp.closed) {
+			cp.cond.Wait()
+		}
+		closed, err := cp.closed, cp.err
+		cp.closed = true
+		cp.cond.Broadcast()
+		cp.mux.Unlock()
+
+		if cerr := cp.closeFiles(); err == nil {
+			err = cerr
+		}
+		if err == nil && closed {
+			err = iostuff.Closed
+		}
+		return err
+	}
+	return cp, wait, nil
+}
+
+// restores pending queries from the state files.
+func (cp *Checkpoint) load() error {
+	data, err := ioutil.ReadAll(cp.qf)
+	if err != nil {
+		return err
+	}
+
+	// the last line could be written partially before crash
+	n := bytes.LastIndexByte(data, '\n') + 1
+	if n < len(data) {
+		if err := cp.qf.Truncate(int64(n)); err != nil {
+			return err
+		}
+	}
+	if _, err := cp.qf.Seek(int64(n), 0); err != nil {
+		return err
+	}
+
+	marks, err := ioutil.ReadAll(cp.mf)
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.Split(data[:n], []byte{'\n'})
+	lines = lines[:len(lines)-1]
+	for i, line := range lines {
+		q := string(line)
+		if ofs := 2*i + 2; ofs <= len(marks) && binary.LittleEndian.Uint16(marks[ofs-2:ofs]) != 0 {
+			continue
+		}
+		if _, ok := cp.state[q]; ok {
+			continue
+		}
+		cp.state[q] = &entry{idx: int64(i), queued: true}
+		cp.pending = append(cp.pending, q)
+	}
+	cp.count = int64(len(lines))
+
+	// unfinished query could push its children before the crash,
+	// remember the finished ones to not scrape them twice.
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		q := string(line)
+		if _, ok := cp.state[q[:len(q)-1]]; !ok {
+			continue
+		}
+		if _, ok := cp.state[q]; !ok {
+			cp.known[q] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (cp *Checkpoint) Pull() (string, func()) {
+	return cp.PullContext(context.Background())
+}
+
+func (cp *Checkpoint) PullContext(ctx context.Context) (string, func()) {
+	if ctx.Done() != nil {
+		// wake up the waiting Pull when ctx is done
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				cp.mux.Lock()
+				cp.cond.Broadcast()
+				cp.mux.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	for len(cp.pending) == 0 && cp.inflight > 0 && !cp.closed && ctx.Err() == nil {
+		cp.cond.Wait()
+	}
+	if len(cp.pending) == 0 || cp.closed || ctx.Err() != nil {
+		return "", nil
+	}
+
+	q := cp.pending[0]
+	cp.pending = cp.pending[1:]
+	cp.state[q].queued = false
+	cp.inflight++
+
+	done := func() {
+		cp.mux.Lock()
+		cp.inflight--
+		cp.cond.Broadcast()
+		cp.mux.Unlock()
+	}
+	return q, done
+}
+
+// Adds new queries to the pipe and appends them to the queries file.
+// The query that is still unfinished isn't written twice, it's just queued again.
+func (cp *Checkpoint) Push(queries []string) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	b := new(bytes.Buffer)
+	for _, q := range queries {
+		if e, ok := cp.state[q]; ok {
+			if !e.queued {
+				e.queued = true
+				cp.pending = append(cp.pending, q)
+			}
+			continue
+		}
+		if _, ok := cp.known[q]; ok {
+			continue
+		}
+
+		cp.state[q] = &entry{idx: cp.count, queued: true}
+		cp.pending = append(cp.pending, q)
+		cp.count++
+
+		b.WriteString(q)
+		b.WriteByte('\n')
+	}
+
+	if b.Len() > 0 {
+		if _, err := cp.qf.Write(b.Bytes()); err != nil {
+			cp.fail(err)
+		}
+	}
+	cp.cond.Broadcast()
+}
+
+// Mark stores Analize mark of the query, so it won't be scraped on resume.
+func (cp *Checkpoint) Mark(q string, mark int16) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	e, ok := cp.state[q]
+	if !ok {
+		return
+	}
+
+	// zero is reserved for unfinished queries, Analize never returns it
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, uint16(mark))
+	if _, err := cp.mf.WriteAt(b, e.idx*2); err != nil {
+		cp.fail(err)
+		return
+	}
+
+	if !e.queued {
+		delete(cp.state, q)
+	}
+}
+
+// Close stops giving out the queries, the pending ones stay on disk for resume.
+func (cp *Checkpoint) Close() {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	cp.closed = true
+	cp.cond.Broadcast()
+}
+
+// should be called under lock
+func (cp *Checkpoint) fail(err error) {
+	if cp.err == nil {
+		cp.err = err
+	}
+	cp.closed = true
+}
+
+func (cp *Checkpoint) closeFiles() error {
+	err := cp.qf.Close()
+	if merr := cp.mf.Close(); err == nil {
+		err = merr
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package scrape_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Loofort/xscrape/hints/scrape"
+	"github.com/Loofort/xscrape/iostuff"
+	"github.com/stretchr/testify/require"
+)
+
+// resumes the crawl that crashed while writing the queries file
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	queries := filepath.Join(dir, "queries")
+	marks := filepath.Join(dir, "queries.idx")
+	putMarks := func(ms ...int16) []byte {
+		b := make([]byte, 2*len(ms))
+		for i, m := range ms {
+			binary.LittleEndian.PutUint16(b[2*i:], uint16(m))
+		}
+		return b
+	}
+
+	// "ac" is unfinished and its child "acx" is finished,
+	// "ad" is beyond the marks file, "par" is the partial line
+	require.NoError(t, ioutil.WriteFile(queries, []byte("a\nab\nac\nacx\nad\npar"), 0644))
+	require.NoError(t, ioutil.WriteFile(marks, putMarks(5, -3, 0, 2), 0644))
+
+	cp, wait, err := scrape.NewCheckpoint(dir, []string{"seed"})
+	require.NoError(t, err)
+
+	q, done := cp.Pull()
+	require.Equal(t, "ac", q)
+	// the known child isn't queued again
+	cp.Push([]string{"acx", "acy"})
+	cp.Mark("ac", -2)
+	done()
+
+	cp.Close()
+	require.Equal(t, iostuff.Closed, wait())
+
+	data, err := ioutil.ReadFile(queries)
+	require.NoError(t, err)
+	require.Equal(t, "a\nab\nac\nacx\nad\nacy\n", string(data))
+	data, err = ioutil.ReadFile(marks)
+	require.NoError(t, err)
+	require.Equal(t, putMarks(5, -3, -2, 2), data)
+
+	// the closed crawl resumes with the pending queries
+	cp, wait, err = scrape.NewCheckpoint(dir, []string{"seed"})
+	require.NoError(t, err)
+	pulled := []string{}
+	for {
+		q, done := cp.Pull()
+		if done == nil {
+			break
+		}
+		pulled = append(pulled, q)
+		cp.Mark(q, 1)
+		done()
+	}
+	require.NoError(t, wait())
+	require.Equal(t, []string{"ad", "acy"}, pulled)
+
+	data, err = ioutil.ReadFile(marks)
+	require.NoError(t, err)
+	require.Equal(t, putMarks(5, -3, -2, 2, 1, 1), data)
+
+	// the finished crawl has nothing to resume
+	cp, wait, err = scrape.NewCheckpoint(dir, []string{"seed"})
+	require.NoError(t, err)
+	_, done = cp.Pull()
+	require.Nil(t, done)
+	require.NoError(t, wait())
+}
@@ -1,11 +1,12 @@
 package scrape
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 
 	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/itunes"
 )
 
 const (
@@ -20,38 +21,70 @@ const (
 
 type Pipe interface {
 	Pull() (query string, done func())
+	PullContext(ctx context.Context) (query string, done func())
 	Push(queries []string)
 }
 
+// Marker is implemented by pipes that keep the crawl progress.
+type Marker interface {
+	Mark(query string, mark int16)
+}
+
 // return true when no more query to scrape
-func Iterate(pipe Pipe, storage io.Writer, priority int16) (bool, error) {
+func Iterate(cfg itunes.Config, pipe Pipe, storage io.Writer, priority int16) (bool, error) {
+	return IterateContext(context.Background(), cfg, pipe, storage, priority)
+}
+
+// also returns true when ctx is done, the interrupted query goes back to the pipe
+func IterateContext(ctx context.Context, cfg itunes.Config, pipe Pipe, storage io.Writer, priority int16) (bool, error) {
 	// get new query to proccess
-	q, done := pipe.Pull()
+	q, done := pipe.PullContext(ctx)
 	if done == nil {
-		return true, nil
+		return true, ctx.Err()
 	}
 	defer done()
 
 	// scrape hints from itunes
-	hs, err := hints.Scrape(q, http.DefaultClient)
+	var hs []hints.Hint
+	err := cfg.Retry.DoContext(ctx, func() (err error) {
+		hs, err = hints.ScrapeContext(ctx, q, cfg)
+		return err
+	})
+	if err != nil && ctx.Err() != nil {
+		pipe.Push([]string{q})
+		return true, ctx.Err()
+	}
 	if err != nil {
+		if itunes.Transient(err) && cfg.Retry.Requeue(q) {
+			pipe.Push([]string{q})
+		} else if derr := cfg.Retry.Bury(q, cfg.Country, err); derr != nil {
+			err = fmt.Errorf("%v; dead-letter: %v", err, derr)
+		}
 		return false, fmt.Errorf("can't scrape '%s': %v", q, err)
 	}
 
+	// the unexpected result isn't saved, the query goes to the dead-letter to be retried later
+	mark, err := Analize(hs)
+	if err != nil {
+		if derr := cfg.Retry.Bury(q, cfg.Country, err); derr != nil {
+			err = fmt.Errorf("%v; dead-letter: %v", err, derr)
+		}
+		return false, fmt.Errorf("unexpected hints result for '%s': %v", q, err)
+	}
+
 	// save hs
 	if len(hs) > 0 {
 		storage.Write(hints.ToBytes(hs))
 	}
 
 	// generate new queries
-	mark, err := Analize(hs)
-	if err != nil {
-		return false, fmt.Errorf("unexpected hints result for '%s': %v", q, err)
-	}
 	if mark >= priority || (priority == 0 && mark == ZeroPriority) {
 		qs := Generate(q)
 		pipe.Push(qs)
 	}
+	if m, ok := pipe.(Marker); ok {
+		m.Mark(q, mark)
+	}
 
 	return false, nil
 }
@@ -66,13 +99,13 @@ func Analize(hs []hints.Hint) (int16, error) {
 		return NoHints, nil
 	}
 	if ln > 50 {
-		return 0, fmt.Errorf("results count %d", ln)
+		return 0, fmt.Errorf("%w: %d", itunes.ErrCount, ln)
 	}
 
 	p := hs[ln-1].Priority
 	for _, h := range hs {
 		if p > h.Priority {
-			return 0, fmt.Errorf("Priority order error")
+			return 0, itunes.ErrOrder
 		}
 	}
 
@@ -0,0 +1,69 @@
+package scrape_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/hints/scrape"
+	"github.com/Loofort/xscrape/iostuff"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/itunestest"
+	"github.com/stretchr/testify/require"
+)
+
+// crawls "a" and its children against the fake itunes
+func TestCrawl(t *testing.T) {
+	s := itunestest.NewServer()
+	defer s.Close()
+
+	// retried until success
+	s.Script("ab", itunestest.Unavailable, itunestest.UnavailablePage)
+	// requeued once, then succeeds
+	s.Script("ac", itunestest.Malformed, itunestest.Malformed, itunestest.Malformed)
+	// schema drift isn't retried
+	s.Script("ad", itunestest.UnknownField)
+	// unexpected result count goes to the dead-letter too
+	s.Script("ae", itunestest.TooMany)
+
+	dead := new(bytes.Buffer)
+	cfg := s.Config()
+	cfg.Retry = itunes.NewRetry(3, 1, time.Millisecond, time.Millisecond)
+	cfg.Retry.Dead = itunes.NewDeadLetter(dead)
+
+	pipe, wait := iostuff.NewBufferPipe([]string{"a"})
+	out := new(bytes.Buffer)
+	iterate := func() (bool, error) {
+		return scrape.Iterate(cfg, pipe, out, 0)
+	}
+	errs := []error{}
+	report := func(err error) {
+		errs = append(errs, err)
+	}
+	join := iostuff.Workers(1, iterate, report)
+	require.NoError(t, wait())
+	join()
+
+	hs, err := hints.FromReader(out)
+	require.NoError(t, err)
+	children := len(scrape.Generate("a"))
+	require.Len(t, hs, 50+(children-2)*3)
+
+	require.Equal(t, 3, s.Requests("ab"))
+	require.Equal(t, 4, s.Requests("ac"))
+	require.Equal(t, 1, s.Requests("ad"))
+	require.Equal(t, 1, s.Requests("ae"))
+	require.Len(t, errs, 3)
+
+	fs, err := itunes.FailuresFromReader(dead)
+	require.NoError(t, err)
+	require.Len(t, fs, 2)
+	classes := map[string]string{}
+	for _, f := range fs {
+		classes[f.Query] = f.Class
+		require.True(t, strings.Contains(f.Error, f.Class))
+	}
+	require.Equal(t, map[string]string{"ad": "schema", "ae": "count"}, classes)
+}
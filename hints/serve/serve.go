@@ -0,0 +1,56 @@
+// Package serve answers the hints requests like itunes does, using the scraped hints.
+// It's the offline stand-in for front-end development and for the scraper itself (see --base-url).
+package serve
+
+import (
+	"net/http"
+
+	"github.com/Loofort/xscrape/hints"
+)
+
+// HintsPath is the itunes hints endpoint
+const HintsPath = "/WebObjects/MZSearchHints.woa/wa/hints"
+
+// MaxHints is the most hints itunes gives for the query
+const MaxHints = 50
+
+// Handler serves the hints scraped for the query in the storefront of cc parameter,
+// so the scraper gets the recorded data back. The query that wasn't scraped
+// gets the top hints of the scraped queries starting with it.
+// The l parameter is ignored, the hints file doesn't keep the language.
+// The response is itunes xml envelope, or json if format=json is set.
+func Handler(it *hints.IndexTree, limit int) http.Handler {
+	if limit <= 0 || limit > MaxHints {
+		limit = MaxHints
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HintsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.FormValue("q")
+		cc := r.FormValue("cc")
+		hs, ok := it.QueryHints(q, cc)
+		if !ok {
+			hs = it.CompleteCountry(q, cc, limit)
+			for i := range hs {
+				hs[i].Query = q
+			}
+		}
+		if len(hs) > limit {
+			hs = hs[:limit]
+		}
+
+		if r.FormValue("format") == "json" {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			hints.EncodeJSON(w, hs)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+		hints.EncodeXML(w, hs)
+	})
+	return mux
+}
@@ -0,0 +1,72 @@
+package serve_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/hints/serve"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/stretchr/testify/require"
+)
+
+// scrapes the stand-in like itunes
+func TestHandler(t *testing.T) {
+	hs := []hints.Hint{
+		{Priority: 8, Query: "ab", Term: "abd", Country: "us"},
+		{Priority: 3, Query: "ab", Term: "abc", Country: "us"},
+		{Priority: 9, Query: "ab", Term: "abx", Country: "gb"},
+		{Priority: 7, Query: "abc", Term: "abc game", Country: "us"},
+		{Priority: 2, Query: "abc", Term: "abc", Country: "us"},
+	}
+	it, err := hints.NewCompleteIndex(bytes.NewReader(hints.ToBytes(hs)), serve.MaxHints)
+	require.NoError(t, err)
+
+	s := httptest.NewServer(serve.Handler(it, 0))
+	defer s.Close()
+
+	scrape := func(q, country string) []hints.Hint {
+		got, err := hints.Scrape(q, itunes.Config{BaseURL: s.URL, Country: country})
+		require.NoError(t, err)
+		return got
+	}
+
+	// the scraped query gets its own hints, highest priority first
+	require.Equal(t, hs[:2], scrape("ab", "us"))
+	require.Equal(t, hs[2:3], scrape("ab", "gb"))
+	require.Equal(t, hs[3:5], scrape("abc", "us"))
+
+	// the prefix that wasn't scraped gets the top of its subtree
+	require.Equal(t, []hints.Hint{
+		{Priority: 8, Query: "a", Term: "abd", Country: "us"},
+		{Priority: 7, Query: "a", Term: "abc game", Country: "us"},
+		{Priority: 3, Query: "a", Term: "abc", Country: "us"},
+	}, scrape("a", "us"))
+
+	require.Empty(t, scrape("abc", "gb"))
+	require.Empty(t, scrape("ab", "de"))
+}
+
+// the sorted file splits the hints of the query into runs of lines
+func TestHandlerSorted(t *testing.T) {
+	hs := []hints.Hint{
+		{Priority: 2, Query: "ab", Term: "abc", Country: "us"},
+		{Priority: 9, Query: "a", Term: "abd", Country: "us"},
+		{Priority: 8, Query: "ab", Term: "abd", Country: "us"},
+		{Priority: 5, Query: "ab", Term: "abe", Country: "us"},
+		// the repeated term is taken with its last priority
+		{Priority: 6, Query: "ab", Term: "abc", Country: "us"},
+	}
+	sorted := append([]hints.Hint{}, hs...)
+	hints.Sort(sorted)
+	it, err := hints.NewCompleteIndex(bytes.NewReader(hints.ToBytes(sorted)), serve.MaxHints)
+	require.NoError(t, err)
+
+	s := httptest.NewServer(serve.Handler(it, 0))
+	defer s.Close()
+
+	got, err := hints.Scrape("ab", itunes.Config{BaseURL: s.URL, Country: "us"})
+	require.NoError(t, err)
+	require.Equal(t, []hints.Hint{hs[2], hs[4], hs[3]}, got)
+}
@@ -0,0 +1,65 @@
+package hints
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// the term url of the hint, Scrape accepts only ihost ones
+func termURL(term string) string {
+	v := url.Values{}
+	v.Set("clientApplication", "Software")
+	v.Set("term", term)
+	return ihost + "WebObjects/MZSearch.woa/wa/search?" + v.Encode()
+}
+
+// EncodeXML writes the hints in the itunes MZSearchHints envelope, the one Scrape parses.
+func EncodeXML(w io.Writer, hs []Hint) error {
+	if _, err := io.WriteString(w, xml.Header+`<plist version="1.0"><dict><key>title</key><string>Suggestions</string><key>hints</key><array>`); err != nil {
+		return err
+	}
+
+	for _, hint := range hs {
+		if _, err := io.WriteString(w, "<dict><key>term</key><string>"); err != nil {
+			return err
+		}
+		if err := xml.EscapeText(w, []byte(hint.Term)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "</string><key>priority</key><integer>%d</integer><key>url</key><string>", hint.Priority); err != nil {
+			return err
+		}
+		if err := xml.EscapeText(w, []byte(termURL(hint.Term))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "</string></dict>"); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "</array></dict></plist>\n")
+	return err
+}
+
+// EncodeJSON writes the same envelope as EncodeXML in json
+func EncodeJSON(w io.Writer, hs []Hint) error {
+	type jsonHint struct {
+		Term     string `json:"term"`
+		Priority int16  `json:"priority"`
+		URL      string `json:"url"`
+	}
+	env := struct {
+		Title string     `json:"title"`
+		Hints []jsonHint `json:"hints"`
+	}{
+		Title: "Suggestions",
+		Hints: make([]jsonHint, 0, len(hs)),
+	}
+	for _, hint := range hs {
+		env.Hints = append(env.Hints, jsonHint{hint.Term, hint.Priority, termURL(hint.Term)})
+	}
+	return json.NewEncoder(w).Encode(env)
+}
@@ -0,0 +1,56 @@
+package iostuff
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// CloseOnSignal closes the pipe on SIGINT or SIGTERM, so the workers finish in-flight queries and exit.
+// notify is called with the caught signal, it could be nil. The next signal kills the process.
+// Returns func that stops watching.
+func CloseOnSignal(pipe Pipe, notify func(os.Signal)) func() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	stopc := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigc:
+			signal.Stop(sigc)
+			if notify != nil {
+				notify(sig)
+			}
+			pipe.Close()
+		case <-stopc:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigc)
+		close(stopc)
+	}
+}
+
+// DefaultFile returns file if it's set, otherwise the name derived from output with suffix.
+// Returns empty string if neither is set.
+func DefaultFile(file, output, suffix string) string {
+	if file == "" && output != "" {
+		file = output + suffix
+	}
+	return file
+}
+
+// SaveFrontier overwrites the frontier file with the unprocessed queries put by write
+func SaveFrontier(frontier string, write func(io.Writer) error) error {
+	w, err := os.OpenFile(frontier, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := write(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
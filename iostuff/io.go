@@ -24,7 +24,8 @@ func InputReader(filename string) (io.ReadCloser, error) {
 	return nil, nil
 }
 
-func inputlines(filename string) ([]string, error) {
+// return all lines from filename if provided, otherwise from stdin
+func InputLines(filename string) ([]string, error) {
 	r, err := InputReader(filename)
 	if err != nil {
 		return nil, err
@@ -70,3 +71,17 @@ func (sf *SafeWriter) Write(p []byte) (int, error) {
 	defer sf.Unlock()
 	return sf.WriteCloser.Write(p)
 }
+
+// Close waits for the current write and flushes the file to disk
+func (sf *SafeWriter) Close() error {
+	sf.Lock()
+	defer sf.Unlock()
+
+	if f, ok := sf.WriteCloser.(*os.File); ok && f != os.Stdout {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return sf.WriteCloser.Close()
+}
@@ -0,0 +1,17 @@
+package iostuff
+
+import "fmt"
+
+// LineError is the error of the particular input line, the lines are counted from 1
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
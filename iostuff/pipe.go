@@ -2,6 +2,7 @@ package iostuff
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"sync"
@@ -14,6 +15,9 @@ type Pipe interface {
 	// done == nil if no more queries to process.
 	Pull() (task string, done func())
 
+	// Same as Pull, but also returns done == nil when ctx is done.
+	PullContext(ctx context.Context) (task string, done func())
+
 	// Adds new strings to the pipe
 	Push(queries []string)
 
@@ -24,11 +28,33 @@ type Pipe interface {
 
 var Closed = fmt.Errorf("the pipe was closed")
 
+// Rester is implemented by the pipes that keep the unprocessed queries in memory.
+type Rester interface {
+	// Returns the queries left in the closed pipe.
+	// it should be called after the pipe wait returns Closed.
+	Rest() []string
+}
+
 type basePipe struct {
 	wg      *sync.WaitGroup
+	once    *sync.Once
 	closec  chan struct{}
 	taskc   chan string
 	resultc chan []string
+	rest    *rest
+}
+
+// collects the queries that weren't processed because the pipe was closed
+type rest struct {
+	mux     *sync.Mutex
+	queries []string
+	scanner *bufio.Scanner
+}
+
+func (r *rest) add(queries []string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.queries = append(r.queries, queries...)
 }
 
 // creates and return memory pipe
@@ -36,9 +62,11 @@ type basePipe struct {
 func newBasePipe(taskc chan string, resultc chan []string) (basePipe, func() error) {
 	pipe := basePipe{
 		wg:      new(sync.WaitGroup),
+		once:    new(sync.Once),
 		closec:  make(chan struct{}),
 		taskc:   taskc,
 		resultc: resultc,
+		rest:    &rest{mux: new(sync.Mutex)},
 	}
 
 	pipe.wg.Add(1)
@@ -58,72 +86,112 @@ func newBasePipe(taskc chan string, resultc chan []string) (basePipe, func() err
 }
 
 func (pipe basePipe) Close() {
-	close(pipe.closec)
+	pipe.once.Do(func() { close(pipe.closec) })
+}
+
+// the unread input of reader pipe is included
+func (pipe basePipe) Rest() []string {
+	pipe.rest.mux.Lock()
+	defer pipe.rest.mux.Unlock()
+
+	queries := pipe.rest.queries
+	if scanner := pipe.rest.scanner; scanner != nil {
+		for scanner.Scan() {
+			queries = append(queries, scanner.Text())
+		}
+		pipe.rest.scanner = nil
+	}
+	pipe.rest.queries = nil
+	return queries
 }
 
 func (pipe basePipe) Pull() (string, func()) {
-	line, ok := <-pipe.taskc
-	return pipe.pull(line, ok)
+	return pipe.PullContext(context.Background())
 }
 
+func (pipe basePipe) PullContext(ctx context.Context) (string, func()) {
+	select {
+	case line, ok := <-pipe.taskc:
+		return pipe.pull(line, ok)
+	case <-ctx.Done():
+		return "", nil
+	}
+}
+
+// the task is already counted by the loop that sent it
 func (pipe basePipe) pull(line string, ok bool) (string, func()) {
 	if !ok {
 		return "", nil
 	}
 
-	pipe.wg.Add(1)
 	done := func() { pipe.wg.Done() }
 	return line, done
 }
 
+// the queries pushed after close are kept for Rest.
+// the push is counted until the loop takes the queries into account,
+// otherwise the pipe could be done in between.
 func (pipe basePipe) Push(queries []string) {
-	pipe.resultc <- queries
+	pipe.wg.Add(1)
+	select {
+	case pipe.resultc <- queries:
+	case <-pipe.closec:
+		pipe.wg.Done()
+		pipe.rest.add(queries)
+	}
 }
 
 /******************* Pipe implementations **********************/
 
 func NewBufferPipe(queries []string) (basePipe, func() error) {
 	pipe, wait := newBasePipe(make(chan string), make(chan []string))
-	pipe.wg.Add(1)
+	if len(queries) > 0 {
+		pipe.wg.Add(1)
+	}
 	go bufferLoop(pipe, queries)
 	return pipe, wait
 }
 
+// the loop holds one wg count while it has queries to give out,
+// so the pipe is done when the buffer is empty and no task is in-flight.
 func bufferLoop(pipe basePipe, queries []string) {
-	defer pipe.wg.Done()
-	query := ""
-	taskc := pipe.taskc
-	if len(queries) == 0 {
-		pipe.wg.Add(1)
-	}
 	for {
-
-		if len(queries) == 0 {
-			if taskc != nil {
-				taskc = nil
-				pipe.wg.Done()
-			}
-		} else {
-			if taskc == nil {
-				taskc = pipe.taskc
-				pipe.wg.Add(1)
-			}
-
-			if query == "" {
-				query = queries[0]
-				queries = queries[1:]
-			}
+		var taskc chan string
+		query := ""
+		if len(queries) > 0 {
+			taskc = pipe.taskc
+			query = queries[0]
+			// count the task before give it out, the receiver could finish it immediately
+			pipe.wg.Add(1)
 		}
 
+		closed := false
 		select {
 		case taskc <- query:
-			query = ""
+			queries = queries[1:]
+			if len(queries) == 0 {
+				pipe.wg.Done()
+			}
+			continue
 		case qs := <-pipe.resultc:
+			if len(queries) == 0 && len(qs) > 0 {
+				pipe.wg.Add(1)
+			}
 			queries = append(queries, qs...)
+			pipe.wg.Done()
 		case <-pipe.closec:
-			if taskc != nil {
+			closed = true
+		}
+
+		// the task wasn't given out
+		if taskc != nil {
+			pipe.wg.Done()
+		}
+		if closed {
+			if len(queries) > 0 {
 				pipe.wg.Done()
 			}
+			pipe.rest.add(queries)
 			return
 		}
 	}
@@ -137,7 +205,9 @@ func NewReaderPipe(r io.Reader) (basePipe, func() error) {
 	go readerLoop(pipe, scanner)
 
 	waitScn := func() error {
-		wait()
+		if err := wait(); err != nil {
+			return err
+		}
 		return scanner.Err()
 	}
 	return pipe, waitScn
@@ -151,60 +221,24 @@ func readerLoop(pipe basePipe, scanner *bufio.Scanner) {
 		}
 
 		line := scanner.Text()
+		// count the task before give it out
+		pipe.wg.Add(1)
 		select {
 		case pipe.taskc <- line:
 		case <-pipe.closec:
+			pipe.wg.Done()
+			pipe.rest.mux.Lock()
+			pipe.rest.queries = append(pipe.rest.queries, line)
+			pipe.rest.scanner = scanner
+			pipe.rest.mux.Unlock()
 			return
 		}
 	}
 }
 
-type memReaderPipe struct {
-	read    Pipe
-	mem     Pipe
-	current Pipe
-	rwmux   *sync.RWMutex
-}
-
+// reads all the lines from reader first, then the pushed ones
 func NewMemReaderPipe(r io.Reader) (Pipe, func() error) {
-	rpipe, rwait := NewReaderPipe(r)
-	mpipe, mwait := NewBufferPipe(nil)
-
-	pipe := memReaderPipe{
-		read:    rpipe,
-		mem:     mpipe,
-		current: rpipe,
-		rwmux:   new(sync.RWMutex),
-	}
-
-	wait := func() error {
-		err := rwait()
-		if err != nil {
-			mpipe.Close()
-			return err
-		}
-
-		pipe.rwmux.Lock()
-		pipe.current = mpipe
-		pipe.rwmux.Unlock()
-
-		return mwait()
-	}
-	return pipe, wait
-}
-
-func (pipe memReaderPipe) Pull() (task string, done func()) {
-	pipe.rwmux.RLock()
-	defer pipe.rwmux.RUnlock()
-	return pipe.current.Pull()
-}
-
-func (pipe memReaderPipe) Push(queries []string) {
-	pipe.mem.Push(queries)
-}
-
-func (pipe memReaderPipe) Close() {
-	pipe.current.Close()
+	return NewStreamPipe(r)
 }
 
 /**************************** *****************************************/
@@ -226,7 +260,9 @@ func NewStreamPipe(r io.Reader) (Pipe, func() error) {
 	wait := func() error {
 		err := rwait()
 		if err != nil {
+			// let in-flight buffer tasks finish
 			buffer.Close()
+			bwait()
 			return err
 		}
 
@@ -236,17 +272,32 @@ func NewStreamPipe(r io.Reader) (Pipe, func() error) {
 }
 
 func (pipe streamPipe) Pull() (string, func()) {
+	return pipe.PullContext(context.Background())
+}
+
+func (pipe streamPipe) PullContext(ctx context.Context) (string, func()) {
 	select {
 	case line, ok := <-pipe.reader.taskc:
-		return pipe.reader.pull(line, ok)
+		if ok {
+			return pipe.reader.pull(line, ok)
+		}
 	default:
 	}
 
-	select {
-	case line, ok := <-pipe.reader.taskc:
-		return pipe.reader.pull(line, ok)
-	case line, ok := <-pipe.buffer.taskc:
-		return pipe.buffer.pull(line, ok)
+	// the reader is closed first, then the buffer
+	readc := pipe.reader.taskc
+	for {
+		select {
+		case line, ok := <-readc:
+			if ok {
+				return pipe.reader.pull(line, ok)
+			}
+			readc = nil
+		case line, ok := <-pipe.buffer.taskc:
+			return pipe.buffer.pull(line, ok)
+		case <-ctx.Done():
+			return "", nil
+		}
 	}
 }
 
@@ -258,3 +309,7 @@ func (pipe streamPipe) Close() {
 	pipe.reader.Close()
 	pipe.buffer.Close()
 }
+
+func (pipe streamPipe) Rest() []string {
+	return append(pipe.reader.Rest(), pipe.buffer.Rest()...)
+}
@@ -0,0 +1,176 @@
+package iostuff
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// SortLines is the external merge sort, it sorts the lines of r by key and writes them to w.
+// At most chunk lines are kept in memory, the sorted chunks are written to temporary files in tmpdir
+// (the default temp directory if empty) and merged at the end. The sort is stable.
+func SortLines(r io.Reader, w io.Writer, key func(line string) (string, error), chunk int, tmpdir string) error {
+	if chunk <= 0 {
+		chunk = 1000000
+	}
+
+	runs := []*os.File{}
+	defer func() {
+		for _, f := range runs {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	lines := make([]keyLine, 0, chunk)
+	for {
+		more := scanner.Scan()
+		if more {
+			k, err := key(scanner.Text())
+			if err != nil {
+				return err
+			}
+			lines = append(lines, keyLine{k, scanner.Text()})
+			if len(lines) < chunk {
+				continue
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].key < lines[j].key
+		})
+
+		// the only chunk doesn't need the merge
+		if !more && len(runs) == 0 {
+			return writeLines(w, lines)
+		}
+
+		if len(lines) > 0 {
+			f, err := ioutil.TempFile(tmpdir, "sort")
+			if err != nil {
+				return err
+			}
+			runs = append(runs, f)
+			if err := writeLines(f, lines); err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, 0); err != nil {
+				return err
+			}
+		}
+		if !more {
+			break
+		}
+		lines = lines[:0]
+	}
+
+	return mergeRuns(runs, w, key)
+}
+
+type keyLine struct {
+	key  string
+	line string
+}
+
+func writeLines(w io.Writer, lines []keyLine) error {
+	bw := bufio.NewWriter(w)
+	for _, kl := range lines {
+		if _, err := bw.WriteString(kl.line); err != nil {
+			return err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// k-way merge of the sorted runs, the equal keys are taken in the runs order
+func mergeRuns(runs []*os.File, w io.Writer, key func(line string) (string, error)) error {
+	h := &runHeap{}
+	for i, f := range runs {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		h.runs = append(h.runs, &run{idx: i, scanner: scanner})
+	}
+
+	// fills the head line of the run, returns false if the run is over
+	next := func(rn *run) (bool, error) {
+		if !rn.scanner.Scan() {
+			return false, rn.scanner.Err()
+		}
+		rn.line = rn.scanner.Text()
+		k, err := key(rn.line)
+		rn.key = k
+		return true, err
+	}
+
+	live := h.runs[:0]
+	for _, rn := range h.runs {
+		ok, err := next(rn)
+		if err != nil {
+			return err
+		}
+		if ok {
+			live = append(live, rn)
+		}
+	}
+	h.runs = live
+	heap.Init(h)
+
+	bw := bufio.NewWriter(w)
+	for h.Len() > 0 {
+		rn := h.runs[0]
+		if _, err := bw.WriteString(rn.line); err != nil {
+			return err
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+
+		ok, err := next(rn)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return bw.Flush()
+}
+
+type run struct {
+	idx     int
+	key     string
+	line    string
+	scanner *bufio.Scanner
+}
+
+type runHeap struct {
+	runs []*run
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+func (h *runHeap) Less(i, j int) bool {
+	if h.runs[i].key != h.runs[j].key {
+		return h.runs[i].key < h.runs[j].key
+	}
+	return h.runs[i].idx < h.runs[j].idx
+}
+func (h *runHeap) Swap(i, j int)      { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	rn := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return rn
+}
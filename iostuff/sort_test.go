@@ -0,0 +1,36 @@
+package iostuff
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortLines(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("%d\t%d", rand.Intn(100), i))
+	}
+	key := func(line string) (string, error) {
+		return strings.SplitN(line, "\t", 2)[0], nil
+	}
+
+	for _, chunk := range []int{1, 7, 1000, 5000} {
+		w := new(bytes.Buffer)
+		err := SortLines(strings.NewReader(strings.Join(lines, "\n")), w, key, chunk, "")
+		require.NoError(t, err)
+
+		expected := append([]string(nil), lines...)
+		sort.SliceStable(expected, func(i, j int) bool {
+			ki, _ := key(expected[i])
+			kj, _ := key(expected[j])
+			return ki < kj
+		})
+		require.Equal(t, strings.Join(expected, "\n")+"\n", w.String(), "chunk %d", chunk)
+	}
+}
@@ -0,0 +1,34 @@
+package iostuff
+
+import "sync"
+
+// Workers runs n goroutines calling iterate until it reports there is no more work.
+// Usually iterate pulls the task from a Pipe, so the pipe blocks the workers while it's empty
+// and they exit after the pipe is drained or closed.
+// The errors are passed to report, it's called concurrently.
+// Returns wait func that returns when all the workers exit.
+// At least one worker is started, even if n is less.
+func Workers(n int, iterate func() (bool, error), report func(error)) func() {
+	if n < 1 {
+		n = 1
+	}
+
+	wg := new(sync.WaitGroup)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				finish, err := iterate()
+				if err != nil {
+					report(err)
+				}
+				if finish {
+					return
+				}
+			}
+		}()
+	}
+
+	return wg.Wait
+}
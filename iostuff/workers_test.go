@@ -0,0 +1,32 @@
+package iostuff
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkers(t *testing.T) {
+	for _, n := range []int{-1, 0, 1, 4} {
+		pipe, wait := NewBufferPipe([]string{"a", "b", "c"})
+		var count int32
+		iterate := func() (bool, error) {
+			q, done := pipe.Pull()
+			if done == nil {
+				return true, nil
+			}
+			defer done()
+
+			atomic.AddInt32(&count, 1)
+			if len(q) < 3 {
+				pipe.Push([]string{q + "x"})
+			}
+			return false, nil
+		}
+		join := Workers(n, iterate, func(err error) { t.Error(err) })
+		require.NoError(t, wait(), "n=%d", n)
+		join()
+		require.Equal(t, int32(9), count, "n=%d", n)
+	}
+}
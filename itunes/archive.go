@@ -0,0 +1,182 @@
+package itunes
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exchange is the archive record of the request and its raw response
+type Exchange struct {
+	Time     time.Time   `json:"time"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Request  http.Header `json:"request,omitempty"`
+	Status   int         `json:"status"`
+	Response http.Header `json:"response,omitempty"`
+	Body     []byte      `json:"body"`
+}
+
+// the exchange is matched by path and query, so the archive could be replayed with other base url
+func exchangeKey(method string, req *http.Request) string {
+	return method + " " + req.URL.RequestURI()
+}
+
+// Recorder is the http.RoundTripper writing every exchange to the gzip json lines archive.
+// It's safe for concurrent use, Close should be called to flush the archive.
+type Recorder struct {
+	mux  *sync.Mutex
+	next http.RoundTripper
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// NewRecorder records the exchanges made by next, http.DefaultTransport is used if next is nil
+func NewRecorder(w io.Writer, next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	gz := gzip.NewWriter(w)
+	return &Recorder{
+		mux:  new(sync.Mutex),
+		next: next,
+		gz:   gz,
+		enc:  json.NewEncoder(gz),
+	}
+}
+
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rec.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	ex := Exchange{
+		Time:     time.Now(),
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Request:  req.Header,
+		Status:   resp.StatusCode,
+		Response: resp.Header,
+		Body:     body,
+	}
+
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+	if err := rec.enc.Encode(ex); err != nil {
+		return nil, fmt.Errorf("can't record %s: %v", ex.URL, err)
+	}
+	return resp, nil
+}
+
+// Close flushes the archive, the underlying writer isn't closed
+func (rec *Recorder) Close() error {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+	return rec.gz.Close()
+}
+
+// Replayer is the http.RoundTripper answering from the archive written by Recorder.
+// The repeated requests get the recorded responses in order, the last one is repeated.
+// The request that wasn't recorded gets 404.
+type Replayer struct {
+	mux       *sync.Mutex
+	exchanges map[string][]Exchange
+}
+
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	rp := &Replayer{
+		mux:       new(sync.Mutex),
+		exchanges: map[string][]Exchange{},
+	}
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		ex := Exchange{}
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			return nil, fmt.Errorf("archive line %d: %v", line, err)
+		}
+
+		req, err := http.NewRequest(ex.Method, ex.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("archive line %d: %v", line, err)
+		}
+		key := exchangeKey(ex.Method, req)
+		rp.exchanges[key] = append(rp.exchanges[key], ex)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rp, nil
+}
+
+func (rp *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	key := exchangeKey(req.Method, req)
+	rp.mux.Lock()
+	exs := rp.exchanges[key]
+	var ex *Exchange
+	if len(exs) > 0 {
+		ex = &exs[0]
+		if len(exs) > 1 {
+			rp.exchanges[key] = exs[1:]
+		}
+	}
+	rp.mux.Unlock()
+
+	if ex == nil {
+		body := "not recorded: " + key
+		return &http.Response{
+			Status:        "404 Not Found",
+			StatusCode:    http.StatusNotFound,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain"}},
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	header := http.Header{}
+	for name, values := range ex.Response {
+		header[name] = values
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+		StatusCode:    ex.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(ex.Body)),
+		ContentLength: int64(len(ex.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,48 @@
+package itunes_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/itunestest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	s := itunestest.NewServer()
+	s.Script("a", itunestest.Unavailable)
+
+	archive := new(bytes.Buffer)
+	rec := itunes.NewRecorder(archive, s.Client().Transport)
+	cfg := s.Config()
+	cfg.Client = &http.Client{Transport: rec}
+
+	_, err := hints.Scrape("a", cfg)
+	require.Error(t, err)
+	recorded, err := hints.Scrape("a", cfg)
+	require.NoError(t, err)
+	require.NoError(t, rec.Close())
+	s.Close()
+
+	rp, err := itunes.NewReplayer(archive)
+	require.NoError(t, err)
+	cfg = itunes.Config{
+		Client:  &http.Client{Transport: rp},
+		BaseURL: "http://replay",
+	}
+
+	_, err = hints.Scrape("a", cfg)
+	require.Error(t, err)
+	hs, err := hints.Scrape("a", cfg)
+	require.NoError(t, err)
+	require.Equal(t, recorded, hs)
+
+	_, err = hints.Scrape("b", cfg)
+	var serr *itunes.StatusError
+	require.True(t, errors.As(err, &serr), "%v", err)
+	require.Equal(t, http.StatusNotFound, serr.Code)
+}
@@ -0,0 +1,188 @@
+package itunes
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps the itunes responses on disk, one file per request, so the repeated runs don't hit apple.
+// The entry expires after ttl, the oldest entries are evicted when the size exceeds the cap.
+// nil Cache doesn't cache anything.
+type Cache struct {
+	mux   *sync.Mutex
+	dir   string
+	ttl   time.Duration
+	max   int64
+	size  int64
+	files map[string]cacheFile
+}
+
+type cacheFile struct {
+	size  int64
+	mtime time.Time
+}
+
+// opens the cache stored in dir, zero max means no size cap
+func NewCache(dir string, ttl time.Duration, max int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		mux:   new(sync.Mutex),
+		dir:   dir,
+		ttl:   ttl,
+		max:   max,
+		files: map[string]cacheFile{},
+	}
+	for _, fi := range fis {
+		// the leftover of the interrupted Put
+		if fi.IsDir() || strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		c.files[fi.Name()] = cacheFile{size: fi.Size(), mtime: fi.ModTime()}
+		c.size += fi.Size()
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.evict()
+	return c, nil
+}
+
+// the key is normalized url and storefront, the query params are sorted and the host is lowercased
+func cacheKey(u, storefront string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u + "|" + storefront
+	}
+	pu.Host = strings.ToLower(pu.Host)
+	pu.RawQuery = pu.Query().Encode()
+	pu.Fragment = ""
+
+	sum := sha1.Sum([]byte(pu.String() + "|" + storefront))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached body, false if it's missed or expired
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	f, ok := c.files[key]
+	if !ok {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(f.mtime) > c.ttl {
+		c.remove(key)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		c.remove(key)
+		return nil, false
+	}
+	return body, true
+}
+
+// Put stores the body, the error is returned if it can't be written
+func (c *Cache) Put(key string, body []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// write then rename, so the concurrent run doesn't read the partial file
+	name := filepath.Join(c.dir, key)
+	if err := ioutil.WriteFile(name+".tmp", body, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+
+	c.size -= c.files[key].size
+	c.files[key] = cacheFile{size: int64(len(body)), mtime: time.Now()}
+	c.size += int64(len(body))
+	c.evict()
+	return nil
+}
+
+// Delete drops the entry, e.g. when the body turns out to be broken
+func (c *Cache) Delete(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.remove(key)
+}
+
+// should be called under lock
+func (c *Cache) remove(key string) {
+	f, ok := c.files[key]
+	if !ok {
+		return
+	}
+	os.Remove(filepath.Join(c.dir, key))
+	c.size -= f.size
+	delete(c.files, key)
+}
+
+// removes the oldest entries until the size fits the cap, should be called under lock
+func (c *Cache) evict() {
+	if c.max <= 0 || c.size <= c.max {
+		return
+	}
+
+	keys := make([]string, 0, len(c.files))
+	for key := range c.files {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.files[keys[i]].mtime.Before(c.files[keys[j]].mtime)
+	})
+	for _, key := range keys {
+		if c.size <= c.max {
+			return
+		}
+		c.remove(key)
+	}
+}
+
+// the cached response looks like the original one for the scrapers
+func cachedResponse(req *http.Request, body []byte) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"X-Cache": {"HIT"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,68 @@
+package itunes_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/itunestest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := itunestest.NewServer()
+	defer s.Close()
+	s.Script("page", itunestest.UnavailablePage)
+	s.Script("malformed", itunestest.Malformed)
+
+	cfg := s.Config()
+	cfg.Cache, err = itunes.NewCache(dir, time.Hour, 0)
+	require.NoError(t, err)
+
+	for _, q := range []string{"a", "page", "malformed"} {
+		hints.Scrape(q, cfg)
+		hints.Scrape(q, cfg)
+	}
+	require.Equal(t, 1, s.Requests("a"))
+	// html and broken bodies aren't reused
+	require.Equal(t, 2, s.Requests("page"))
+	require.Equal(t, 2, s.Requests("malformed"))
+
+	// the storefront is the part of the key, the url is the same
+	for _, sf := range []string{"143441-1,29", "143444-1,29", "143441-1,29"} {
+		cfg.Header = http.Header{"X-Apple-Store-Front": {sf}}
+		hints.Scrape("a", cfg)
+	}
+	require.Equal(t, 3, s.Requests("a"))
+	cfg.Header = nil
+
+	// expired
+	cfg.Cache, err = itunes.NewCache(dir, time.Nanosecond, 0)
+	require.NoError(t, err)
+	hints.Scrape("a", cfg)
+	require.Equal(t, 4, s.Requests("a"))
+
+	// the leftover of the interrupted write isn't cached
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "leftover.tmp"), []byte("{}"), 0644))
+	cfg.Cache, err = itunes.NewCache(dir, time.Hour, 0)
+	require.NoError(t, err)
+	_, ok := cfg.Cache.Get("leftover.tmp")
+	require.False(t, ok)
+
+	// nothing fits 1 byte cap, the leftover is the only file
+	cfg.Cache, err = itunes.NewCache(dir, time.Hour, 1)
+	require.NoError(t, err)
+	fis, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, fis, 1)
+	require.Equal(t, "leftover.tmp", fis[0].Name())
+}
@@ -0,0 +1,135 @@
+package itunes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Config describes how the scrapers reach itunes.
+// Zero Config talks to apple with http.DefaultClient.
+type Config struct {
+	Client *http.Client
+
+	// overrides the default endpoint host, e.g. to target mirror, proxy or local stand-in
+	BaseURL string
+
+	Country   string
+	Lang      string
+	UserAgent string
+
+	// extra headers sent with every request, e.g. X-Apple-Store-Front
+	Header http.Header
+
+	// shared by all workers, nil means no limit
+	Limiter *Limiter
+
+	// failed queries policy, nil means no retry
+	Retry *Retry
+
+	// on-disk responses cache, nil means no cache
+	Cache *Cache
+}
+
+// URL builds the endpoint url on top of BaseURL, or defaultBase if BaseURL is empty
+func (cfg Config) URL(defaultBase, path string, v url.Values) string {
+	base := cfg.BaseURL
+	if base == "" {
+		base = defaultBase
+	}
+	return strings.TrimSuffix(base, "/") + path + "?" + v.Encode()
+}
+
+// Get requests url with configured client and headers.
+// it waits for the limiter and slows it down if itunes refuses to serve.
+// the cached response doesn't wait for the limiter.
+func (cfg Config) Get(url string) (*http.Response, error) {
+	return cfg.GetContext(context.Background(), url)
+}
+
+func (cfg Config) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for name, values := range cfg.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+	if sf := StoreFront(cfg.Country); sf != "" && req.Header.Get("X-Apple-Store-Front") == "" {
+		req.Header.Set("X-Apple-Store-Front", sf)
+	}
+
+	key := cacheKey(url, req.Header.Get("X-Apple-Store-Front"))
+	if body, ok := cfg.Cache.Get(key); ok {
+		return cachedResponse(req, body), nil
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if err := cfg.Limiter.WaitContext(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests {
+		cfg.Limiter.Slowdown()
+	}
+
+	// the html page is apple's refusal, it's not cached
+	if cfg.Cache == nil || resp.StatusCode != http.StatusOK ||
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	// the response is still good if it can't be cached
+	cfg.Cache.Put(key, body)
+	return resp, nil
+}
+
+// Uncache drops the cached response of url, the scrapers call it when the response can't be parsed
+func (cfg Config) Uncache(url string) {
+	if cfg.Cache == nil {
+		return
+	}
+
+	sf := cfg.Header.Get("X-Apple-Store-Front")
+	if sf == "" {
+		sf = StoreFront(cfg.Country)
+	}
+	cfg.Cache.Delete(cacheKey(url, sf))
+}
+
+// ParseHeader parses headers in "Name: value" form
+func ParseHeader(lines []string) (http.Header, error) {
+	header := http.Header{}
+	for _, line := range lines {
+		pices := strings.SplitN(line, ":", 2)
+		if len(pices) != 2 || strings.TrimSpace(pices[0]) == "" {
+			return nil, fmt.Errorf("incorrect header: %s", line)
+		}
+		header.Add(strings.TrimSpace(pices[0]), strings.TrimSpace(pices[1]))
+	}
+	return header, nil
+}
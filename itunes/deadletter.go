@@ -0,0 +1,63 @@
+package itunes
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Failure is the dead-letter record of the query that couldn't be scraped
+type Failure struct {
+	Query    string    `json:"query"`
+	Country  string    `json:"country,omitempty"`
+	Class    string    `json:"class"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"`
+}
+
+// DeadLetter writes failures as json lines, it's safe for concurrent use
+type DeadLetter struct {
+	mux *sync.Mutex
+	w   io.Writer
+}
+
+func NewDeadLetter(w io.Writer) *DeadLetter {
+	return &DeadLetter{
+		mux: new(sync.Mutex),
+		w:   w,
+	}
+}
+
+func (dl *DeadLetter) Write(f Failure) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	dl.mux.Lock()
+	defer dl.mux.Unlock()
+	_, err = dl.w.Write(append(b, '\n'))
+	return err
+}
+
+func FailuresFromReader(reader io.Reader) ([]Failure, error) {
+	scanner := bufio.NewScanner(reader)
+	fs := []Failure{}
+	for scanner.Scan() {
+		f := Failure{}
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return nil, fmt.Errorf("incorrect line: %s", scanner.Text())
+		}
+		fs = append(fs, f)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
@@ -0,0 +1,84 @@
+package itunes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+)
+
+// scrape error kinds, check them with errors.Is
+var (
+	ErrUnavailable = errors.New("service unavailable")
+	ErrEnvelope    = errors.New("malformed envelope")
+	ErrSchema      = errors.New("schema drift")
+	ErrCount       = errors.New("unexpected result count")
+	ErrOrder       = errors.New("priority order violation")
+	ErrDuplicate   = errors.New("duplicate bundle in response")
+)
+
+// StatusError is returned when itunes responds with unexpected http status.
+// 503 and 429 statuses match ErrUnavailable.
+type StatusError struct {
+	Code int
+	Dump []byte
+}
+
+// dumps the response into the error
+func NewStatusError(resp *http.Response) *StatusError {
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		dump = []byte(fmt.Sprintf("cant dump resp: %v", err))
+	}
+	return &StatusError{Code: resp.StatusCode, Dump: dump}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected http status %d; dump: %s", e.Code, e.Dump)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnavailable &&
+		(e.Code == http.StatusServiceUnavailable || e.Code == http.StatusTooManyRequests)
+}
+
+// Transient reports whether the request could succeed if it's repeated later
+func Transient(err error) bool {
+	var nerr net.Error
+	var serr *StatusError
+	switch {
+	case errors.Is(err, ErrUnavailable), errors.Is(err, ErrEnvelope):
+		return true
+	case errors.As(err, &nerr):
+		return true
+	case errors.As(err, &serr):
+		return serr.Code >= 500
+	}
+	return false
+}
+
+// Classify returns short class name of the scrape error
+func Classify(err error) string {
+	var nerr net.Error
+	var serr *StatusError
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrEnvelope):
+		return "envelope"
+	case errors.Is(err, ErrSchema):
+		return "schema"
+	case errors.Is(err, ErrCount):
+		return "count"
+	case errors.Is(err, ErrOrder):
+		return "order"
+	case errors.Is(err, ErrDuplicate):
+		return "duplicate"
+	case errors.As(err, &serr):
+		return "status"
+	case errors.As(err, &nerr):
+		return "network"
+	}
+	return "scrape"
+}
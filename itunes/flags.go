@@ -0,0 +1,148 @@
+package itunes
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alecthomas/units"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// ClientFlags are the command line flags the Config is built from.
+type ClientFlags struct {
+	baseURL    *string
+	lang       *string
+	agent      *string
+	headers    *[]string
+	rate       *float64
+	attempts   *int
+	backoff    *time.Duration
+	maxBackoff *time.Duration
+	record     *string
+	replay     *string
+	cache      *string
+	cacheTTL   *time.Duration
+	cacheSize  *units.Base2Bytes
+
+	// registered by NewCrawlFlags only
+	requeue    *int
+	deadLetter *string
+}
+
+// NewClientFlags registers the client flags of cmd.
+// item names the requested thing in the help, e.g. query or term; rate is the default requests per second.
+func NewClientFlags(cmd *kingpin.CmdClause, item string, rate float64) *ClientFlags {
+	return &ClientFlags{
+		baseURL:    cmd.Flag("base-url", "itunes host, e.g. mirror, proxy or local stand-in").Default("").String(),
+		lang:       cmd.Flag("lang", "results language, e.g. en_us").Default("").Short('l').String(),
+		agent:      cmd.Flag("user-agent", "User-Agent header").Default("").String(),
+		headers:    cmd.Flag("header", "extra request header 'Name: value', repeatable").Short('H').Strings(),
+		rate:       cmd.Flag("rate", "max requests per second shared by all workers, 0 means no limit").Default(strconv.FormatFloat(rate, 'f', -1, 64)).Float64(),
+		attempts:   cmd.Flag("attempts", "max attempts per "+item).Default("3").Int(),
+		backoff:    cmd.Flag("backoff", "initial delay between attempts").Default("1s").Duration(),
+		maxBackoff: cmd.Flag("max-backoff", "max delay between attempts").Default("1m").Duration(),
+		record:     cmd.Flag("record", "gzip archive file to record the raw itunes exchanges to").Default("").String(),
+		replay:     cmd.Flag("replay", "answer from the recorded archive instead of itunes").Default("").String(),
+		cache:      cmd.Flag("cache", "directory to cache the itunes responses in").Default("").String(),
+		cacheTTL:   cmd.Flag("cache-ttl", "how long the cached response is used").Default("24h").Duration(),
+		cacheSize:  cmd.Flag("cache-size", "max cache size, the oldest responses are evicted").Default("1GB").Bytes(),
+	}
+}
+
+// NewCrawlFlags also registers the flags of the commands crawling the pipe:
+// how many times the failed item is requeued and the dead-letter file.
+// items is the plural of item, e.g. queries.
+func NewCrawlFlags(cmd *kingpin.CmdClause, item, items string, rate float64) *ClientFlags {
+	f := NewClientFlags(cmd, item, rate)
+	f.requeue = cmd.Flag("requeue", "how many times the failed "+item+" goes back to the queue").Default("1").Int()
+	f.deadLetter = cmd.Flag("dead-letter", "file to append the "+items+" failed for good").Default("").Short('d').String()
+	return f
+}
+
+// Config builds the config from the parsed flags.
+// The returned func closes the dead-letter and archive files, it should be called when the scrape is done.
+func (f *ClientFlags) Config() (Config, func() error, error) {
+	header, err := ParseHeader(*f.headers)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if *f.record != "" && *f.replay != "" {
+		return Config{}, nil, errors.New("--record and --replay can't be used together")
+	}
+
+	cfg := Config{
+		BaseURL:   *f.baseURL,
+		Lang:      *f.lang,
+		UserAgent: *f.agent,
+		Header:    header,
+	}
+	if *f.rate > 0 {
+		cfg.Limiter = NewLimiter(*f.rate)
+	}
+	requeue := 0
+	if f.requeue != nil {
+		requeue = *f.requeue
+	}
+	cfg.Retry = NewRetry(*f.attempts, requeue, *f.backoff, *f.maxBackoff)
+
+	closers := []func() error{}
+	closeAll := func() error {
+		var first error
+		for _, c := range closers {
+			if err := c(); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+	fail := func(err error) (Config, func() error, error) {
+		closeAll()
+		return Config{}, nil, err
+	}
+
+	if f.deadLetter != nil && *f.deadLetter != "" {
+		w, err := os.OpenFile(*f.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fail(err)
+		}
+		cfg.Retry.Dead = NewDeadLetter(w)
+		closers = append(closers, w.Close)
+	}
+
+	if *f.cache != "" {
+		cache, err := NewCache(*f.cache, *f.cacheTTL, int64(*f.cacheSize))
+		if err != nil {
+			return fail(err)
+		}
+		cfg.Cache = cache
+	}
+
+	if *f.record != "" {
+		w, err := os.Create(*f.record)
+		if err != nil {
+			return fail(err)
+		}
+		rec := NewRecorder(w, nil)
+		cfg.Client = &http.Client{Transport: rec}
+		closers = append(closers, rec.Close, w.Close)
+	}
+	if *f.replay != "" {
+		r, err := os.Open(*f.replay)
+		if err != nil {
+			return fail(err)
+		}
+		rp, err := NewReplayer(r)
+		r.Close()
+		if err != nil {
+			return fail(err)
+		}
+		cfg.Client = &http.Client{Transport: rp}
+		// no network, no need to wait
+		cfg.Limiter = nil
+	}
+
+	return cfg, closeAll, nil
+}
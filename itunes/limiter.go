@@ -0,0 +1,113 @@
+package itunes
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is the token bucket shared by all scrape workers.
+// It slows down when itunes complains and gradually recovers up to the configured rate.
+// nil Limiter doesn't limit anything.
+type Limiter struct {
+	mux    *sync.Mutex
+	max    float64
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// creates limiter allowing rate requests per second
+func NewLimiter(rate float64) *Limiter {
+	burst := math.Max(1, math.Ceil(rate))
+	return &Limiter{
+		mux:    new(sync.Mutex),
+		max:    rate,
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until the next request is allowed
+func (l *Limiter) Wait() {
+	l.WaitContext(context.Background())
+}
+
+// WaitContext blocks until the next request is allowed or ctx is done
+func (l *Limiter) WaitContext(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mux.Lock()
+	l.refill()
+	// reserve the token, the debt is paid off by sleeping
+	l.tokens--
+	var sleep time.Duration
+	if l.tokens < 0 {
+		sleep = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mux.Unlock()
+
+	timer := time.NewTimer(sleep)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the reserved token back
+		l.mux.Lock()
+		l.tokens++
+		l.mux.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Slowdown halves the rate, it's called when itunes refuses to serve
+func (l *Limiter) Slowdown() {
+	if l == nil {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.refill()
+	l.rate = math.Max(l.rate/2, l.max/64)
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+}
+
+// Recover gradually returns the rate back to max, it's called on successful response
+func (l *Limiter) Recover() {
+	if l == nil {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.refill()
+	l.rate = math.Min(l.rate+l.max/20, l.max)
+}
+
+// Rate returns current requests per second
+func (l *Limiter) Rate() float64 {
+	if l == nil {
+		return math.Inf(1)
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.rate
+}
+
+// should be called under lock
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
@@ -0,0 +1,126 @@
+package itunes
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Retry is the policy for failed queries.
+// The query is tried several times with exponential back-off and jitter,
+// after that it could go back to the pipe limited number of times.
+// nil Retry tries once and never requeues.
+type Retry struct {
+	// receives the queries that run out of attempts, could be nil
+	Dead *DeadLetter
+
+	attempts int
+	requeue  int
+	base     time.Duration
+	max      time.Duration
+
+	mux      *sync.Mutex
+	requeued map[string]int
+}
+
+func NewRetry(attempts, requeue int, base, max time.Duration) *Retry {
+	return &Retry{
+		attempts: attempts,
+		requeue:  requeue,
+		base:     base,
+		max:      max,
+		mux:      new(sync.Mutex),
+		requeued: map[string]int{},
+	}
+}
+
+// Do calls scrape until it succeeds, fails for good or the attempts are exhausted.
+// returns the last error.
+func (r *Retry) Do(scrape func() error) error {
+	return r.DoContext(context.Background(), scrape)
+}
+
+// DoContext stops retrying when ctx is done
+func (r *Retry) DoContext(ctx context.Context, scrape func() error) error {
+	err := scrape()
+	if r == nil {
+		return err
+	}
+
+	for i := 1; i < r.attempts && Transient(err); i++ {
+		timer := time.NewTimer(r.backoff(i))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		err = scrape()
+	}
+	return err
+}
+
+// Requeue reports whether the failed query should go back to the pipe.
+func (r *Retry) Requeue(q string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.requeued[q] >= r.requeue {
+		return false
+	}
+	r.requeued[q]++
+	return true
+}
+
+// Bury writes the query that is failed for good to the dead-letter
+func (r *Retry) Bury(q, country string, err error) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mux.Lock()
+	requeued := r.requeued[q]
+	delete(r.requeued, q)
+	r.mux.Unlock()
+
+	if r.Dead == nil {
+		return nil
+	}
+
+	attempts := r.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	return r.Dead.Write(Failure{
+		Query:    q,
+		Country:  country,
+		Class:    Classify(err),
+		Error:    err.Error(),
+		Attempts: attempts * (requeued + 1),
+		Time:     time.Now(),
+	})
+}
+
+// returns delay before the attempt, half of it is random
+func (r *Retry) backoff(attempt int) time.Duration {
+	// doubles up to max instead of shifting, so the many attempts don't overflow
+	d := r.base
+	for i := 1; i < attempt && d > 0 && d < r.max; i++ {
+		if d > r.max/2 {
+			d = r.max
+			break
+		}
+		d *= 2
+	}
+	if d > r.max {
+		d = r.max
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
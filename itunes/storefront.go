@@ -0,0 +1,51 @@
+package itunes
+
+import "strconv"
+
+// apple storefront ids by country code
+var storeFronts = map[string]int{
+	"us": 143441,
+	"fr": 143442,
+	"de": 143443,
+	"gb": 143444,
+	"at": 143445,
+	"be": 143446,
+	"fi": 143447,
+	"gr": 143448,
+	"ie": 143449,
+	"it": 143450,
+	"lu": 143451,
+	"nl": 143452,
+	"pt": 143453,
+	"es": 143454,
+	"ca": 143455,
+	"se": 143456,
+	"no": 143457,
+	"dk": 143458,
+	"ch": 143459,
+	"au": 143460,
+	"nz": 143461,
+	"jp": 143462,
+	"hk": 143463,
+	"sg": 143464,
+	"cn": 143465,
+	"kr": 143466,
+	"in": 143467,
+	"mx": 143468,
+	"ru": 143469,
+	"tw": 143470,
+	"br": 143503,
+	"pl": 143478,
+	"tr": 143480,
+	"ua": 143492,
+}
+
+// StoreFront returns X-Apple-Store-Front value for the country
+// or empty string if the country is unknown
+func StoreFront(country string) string {
+	id, ok := storeFronts[country]
+	if !ok {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,316 @@
+// Package itunestest provides the fake itunes server for tests and offline development.
+// It answers the hints xml endpoint and the search and lookup json endpoints,
+// the answers are scripted per query with scenarios.
+package itunestest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Loofort/xscrape/hints"
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/search"
+)
+
+const (
+	HintsPath  = "/WebObjects/MZSearchHints.woa/wa/hints"
+	SearchPath = "/search"
+	LookupPath = "/lookup"
+)
+
+// Scenario describes how the server answers the request
+type Scenario int
+
+const (
+	OK Scenario = iota
+	// 503 status with html page
+	Unavailable
+	// 200 status with html page, that's how the hints endpoint refuses
+	UnavailablePage
+	// truncated xml or json
+	Malformed
+	// more than 50 results
+	TooMany
+	// the first result is repeated at the end
+	Duplicate
+	// the results have the field the scrapers don't know
+	UnknownField
+	// the answer is delayed by Server.Delay
+	Slow
+)
+
+var scenarioNames = []string{"ok", "unavailable", "unavailable-page", "malformed", "too-many", "duplicate", "unknown-field", "slow"}
+
+func (sc Scenario) String() string {
+	if int(sc) < len(scenarioNames) {
+		return scenarioNames[sc]
+	}
+	return "scenario(" + strconv.Itoa(int(sc)) + ")"
+}
+
+const unavailablePage = "<html><body><b>Http/1.1 Service Unavailable</b></body> </html>"
+
+// Server is the fake itunes.
+// The fields should be set before the requests are made.
+type Server struct {
+	*httptest.Server
+
+	// the scenario of the query that isn't scripted
+	Default Scenario
+
+	// the delay of Slow scenario
+	Delay time.Duration
+
+	// generates hints of the query, DefaultHints is used if nil
+	Hints func(q string) []hints.Hint
+
+	// generates limit apps of the term, DefaultApps is used if nil
+	Apps func(term string, limit int) []search.App
+
+	mux      *sync.Mutex
+	scripts  map[string][]Scenario
+	requests map[string]int
+}
+
+// NewServer starts the fake itunes, it should be closed by Close.
+func NewServer() *Server {
+	s := &Server{
+		Delay:    time.Second,
+		mux:      new(sync.Mutex),
+		scripts:  map[string][]Scenario{},
+		requests: map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HintsPath, s.serveHints)
+	mux.HandleFunc(SearchPath, s.serveSearch)
+	mux.HandleFunc(LookupPath, s.serveLookup)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns the config targeting the server
+func (s *Server) Config() itunes.Config {
+	return itunes.Config{
+		Client:  s.Client(),
+		BaseURL: s.URL,
+	}
+}
+
+// Script sets the scenarios of the next requests of the query (hints), term (search)
+// or comma separated ids (lookup), one per request.
+// The requests after them get Default.
+func (s *Server) Script(q string, scs ...Scenario) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.scripts[q] = append(s.scripts[q], scs...)
+}
+
+// Requests returns how many times the query or term was requested
+func (s *Server) Requests(q string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.requests[q]
+}
+
+// counts the request and returns its scenario
+func (s *Server) next(q string) Scenario {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.requests[q]++
+	scs := s.scripts[q]
+	if len(scs) == 0 {
+		return s.Default
+	}
+	s.scripts[q] = scs[1:]
+	return scs[0]
+}
+
+// answers the common scenarios, returns false if the handler should write the results
+func (s *Server) common(w http.ResponseWriter, r *http.Request, sc Scenario) bool {
+	switch sc {
+	case Unavailable:
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, unavailablePage)
+		return true
+	case UnavailablePage:
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, unavailablePage)
+		return true
+	case Slow:
+		select {
+		case <-time.After(s.Delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveHints(w http.ResponseWriter, r *http.Request) {
+	q := r.FormValue("q")
+	sc := s.next(q)
+	if s.common(w, r, sc) {
+		return
+	}
+
+	gen := s.Hints
+	if gen == nil {
+		gen = DefaultHints
+	}
+	hs := gen(q)
+
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	switch sc {
+	case TooMany:
+		hs = fillHints(q, 51)
+	case Duplicate:
+		if len(hs) > 0 {
+			hs = append(hs[:len(hs):len(hs)], hs[0])
+		}
+	case Malformed:
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict><key>title</key><string>Sugg`)
+		return
+	case UnknownField:
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict><key>title</key><string>Suggestions</string><key>hints</key><array>`)
+		for _, hint := range hs {
+			io.WriteString(w, "<dict><key>term</key><string>")
+			xml.EscapeText(w, []byte(hint.Term))
+			fmt.Fprintf(w, "</string><key>priority</key><integer>%d</integer><key>url</key><string>https://search.itunes.apple.com/</string><key>kind</key><string>software</string></dict>", hint.Priority)
+		}
+		io.WriteString(w, "</array></dict></plist>")
+		return
+	}
+	hints.EncodeXML(w, hs)
+}
+
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	term := r.FormValue("term")
+	sc := s.next(term)
+	if s.common(w, r, sc) {
+		return
+	}
+
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	gen := s.Apps
+	if gen == nil {
+		gen = DefaultApps
+	}
+	apps := gen(term, limit)
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	switch sc {
+	case TooMany:
+		apps = DefaultApps(term, limit+1)
+	case Duplicate:
+		if len(apps) > 0 {
+			apps = append(apps[:len(apps):len(apps)], apps[0])
+		}
+	case Malformed:
+		io.WriteString(w, `{"resultCount":1,"results":[{"bundleId":"com.`)
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(apps))
+	for _, app := range apps {
+		b, err := json.Marshal(app)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m := map[string]interface{}{}
+		json.Unmarshal(b, &m)
+		if sc == UnknownField {
+			m["newField"] = true
+		}
+		results = append(results, m)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resultCount": len(results),
+		"results":     results,
+	})
+}
+
+// the known ids are answered with one app each, the ids starting with "unknown" are missed
+func (s *Server) serveLookup(w http.ResponseWriter, r *http.Request) {
+	bundles, tracks := r.FormValue("bundleId"), r.FormValue("id")
+	sc := s.next(bundles + tracks)
+	if s.common(w, r, sc) {
+		return
+	}
+	if sc == Malformed {
+		io.WriteString(w, `{"resultCount":1,"results":[{"bundleId":"com.`)
+		return
+	}
+
+	apps := []search.App{}
+	for _, id := range strings.Split(bundles, ",") {
+		if id != "" && !strings.HasPrefix(id, "unknown") {
+			apps = append(apps, search.App{BundleID: id, TrackName: id, Kind: "software"})
+		}
+	}
+	for _, id := range strings.Split(tracks, ",") {
+		if n, err := strconv.Atoi(id); err == nil {
+			apps = append(apps, search.App{BundleID: "com.track" + id, TrackID: n, Kind: "software"})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resultCount": len(apps),
+		"results":     apps,
+	})
+}
+
+// DefaultHints gives 50 hints for the query up to 1 char, so the crawl goes one level deeper,
+// and 3 hints for the longer ones.
+func DefaultHints(q string) []hints.Hint {
+	if len(q) <= 1 {
+		return fillHints(q, 50)
+	}
+	return fillHints(q, 3)
+}
+
+// n hints in the itunes order, the priority goes down
+func fillHints(q string, n int) []hints.Hint {
+	hs := make([]hints.Hint, 0, n)
+	for i := 0; i < n; i++ {
+		hs = append(hs, hints.Hint{
+			Priority: int16(100 - i),
+			Query:    q,
+			Term:     q + " " + strconv.Itoa(i),
+		})
+	}
+	return hs
+}
+
+// DefaultApps gives limit apps of the term
+func DefaultApps(term string, limit int) []search.App {
+	// the bundle id can't have spaces, they separate the bundles in search file
+	name := strings.Replace(term, " ", "-", -1)
+	apps := make([]search.App, 0, limit)
+	for i := 0; i < limit; i++ {
+		apps = append(apps, search.App{
+			BundleID:  fmt.Sprintf("com.%s.app%d", name, i),
+			TrackID:   1000 + i,
+			TrackName: fmt.Sprintf("%s app %d", term, i),
+			Kind:      "software",
+		})
+	}
+	return apps
+}
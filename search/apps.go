@@ -0,0 +1,120 @@
+package search
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Loofort/xscrape/iostuff"
+)
+
+// AppRecord is the line of the apps file, the app metadata at the moment of scrape
+type AppRecord struct {
+	Time    time.Time `json:"scrapedAt"`
+	Country string    `json:"country,omitempty"`
+	App
+}
+
+// AppWriter writes the apps as json lines, every app once per country and snapshot (writer life).
+// It's safe for concurrent use, nil AppWriter doesn't write anything.
+type AppWriter struct {
+	mux  *sync.Mutex
+	w    io.Writer
+	seen map[string]struct{}
+}
+
+func NewAppWriter(w io.Writer) *AppWriter {
+	return &AppWriter{
+		mux:  new(sync.Mutex),
+		w:    w,
+		seen: map[string]struct{}{},
+	}
+}
+
+// AppID identifies the app by its bundle, the track id is used if the bundle is missing.
+func AppID(app App) string {
+	if app.BundleID != "" {
+		return app.BundleID
+	}
+	return "#" + strconv.Itoa(app.TrackID)
+}
+
+// the app is identified by storefront country and AppID.
+// the price, currency and ratings differ per storefront, so the app is written for every country.
+func appKey(country string, app App) string {
+	return country + " " + AppID(app)
+}
+
+// Write writes the apps that weren't written yet
+func (aw *AppWriter) Write(country string, apps []App) error {
+	if aw == nil {
+		return nil
+	}
+
+	aw.mux.Lock()
+	defer aw.mux.Unlock()
+
+	now := time.Now()
+	for _, app := range apps {
+		key := appKey(country, app)
+		if _, ok := aw.seen[key]; ok {
+			continue
+		}
+
+		b, err := json.Marshal(AppRecord{Time: now, Country: country, App: app})
+		if err != nil {
+			return err
+		}
+		if _, err := aw.w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		aw.seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// AppReader reads apps file record by record in constant memory, see Reader
+type AppReader struct {
+	scanner *bufio.Scanner
+	record  AppRecord
+	line    int
+	err     error
+}
+
+func NewAppReader(r io.Reader) *AppReader {
+	scanner := bufio.NewScanner(r)
+	// the description could be long
+	scanner.Buffer(nil, 16*1024*1024)
+	return &AppReader{scanner: scanner}
+}
+
+// Next reads the next record, it returns false at the end of input or on error
+func (r *AppReader) Next() bool {
+	if r.err != nil || !r.scanner.Scan() {
+		if r.err == nil && r.scanner.Err() != nil {
+			r.err = &iostuff.LineError{Line: r.line + 1, Err: r.scanner.Err()}
+		}
+		return false
+	}
+
+	r.line++
+	r.record = AppRecord{}
+	if err := json.Unmarshal(r.scanner.Bytes(), &r.record); err != nil {
+		r.err = &iostuff.LineError{Line: r.line, Err: err}
+		return false
+	}
+	return true
+}
+
+// Record returns the record read by the last Next
+func (r *AppReader) Record() AppRecord {
+	return r.record
+}
+
+// Err returns the first error, it's *iostuff.LineError with the line number
+func (r *AppReader) Err() error {
+	return r.err
+}
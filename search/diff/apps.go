@@ -0,0 +1,192 @@
+package diff
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Loofort/xscrape/search"
+)
+
+// AppChangeKind is the kind of app metadata change
+type AppChangeKind string
+
+// the kinds in the order they're reported for the app
+const (
+	AppNew          AppChangeKind = "new"
+	AppGone         AppChangeKind = "gone"
+	AppVersion      AppChangeKind = "version"
+	AppReleaseNotes AppChangeKind = "release-notes"
+	AppPrice        AppChangeKind = "price"
+	AppCurrency     AppChangeKind = "currency"
+	AppRatingCount  AppChangeKind = "rating-count"
+	AppGenre        AppChangeKind = "genre"
+	AppScreenshots  AppChangeKind = "screenshots"
+	AppSeller       AppChangeKind = "seller"
+	AppArtist       AppChangeKind = "artist"
+)
+
+// AppChange is the change of the app between two snapshots.
+// Delta is set for the numeric changes: price, rating count and the number of new screenshots.
+type AppChange struct {
+	Kind     AppChangeKind
+	BundleID string
+	TrackID  int
+	Country  string
+	Old      string
+	New      string
+	Delta    float64
+}
+
+func (ch AppChange) String() string {
+	delta := strconv.FormatFloat(ch.Delta, 'f', -1, 64)
+	// the release notes are multiline
+	old := strings.Replace(ch.Old, "\n", "\\n", -1)
+	new := strings.Replace(ch.New, "\n", "\\n", -1)
+	line := string(ch.Kind) + "\t" + ch.BundleID + "\t" + delta + "\t" + old + "\t" + new
+	if ch.Country != "" {
+		line += "\t" + ch.Country
+	}
+	return line
+}
+
+// the same key the apps writer uses
+type appKey struct {
+	country string
+	id      string
+}
+
+// reads apps file, the latest record of the app is taken
+func readApps(r io.Reader) (map[appKey]search.AppRecord, error) {
+	apps := map[appKey]search.AppRecord{}
+	ar := search.NewAppReader(r)
+	for ar.Next() {
+		rec := ar.Record()
+		apps[appKey{rec.Country, search.AppID(rec.App)}] = rec
+	}
+	return apps, ar.Err()
+}
+
+// DiffApps compares two apps files and returns the changes ordered by country and bundle
+func DiffApps(r1, r2 io.Reader) ([]AppChange, error) {
+	apps1, err := readApps(r1)
+	if err != nil {
+		return nil, err
+	}
+
+	apps2, err := readApps(r2)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]appKey, 0, len(apps2))
+	for key := range apps1 {
+		keys = append(keys, key)
+	}
+	for key := range apps2 {
+		if _, ok := apps1[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].country != keys[j].country {
+			return keys[i].country < keys[j].country
+		}
+		return keys[i].id < keys[j].id
+	})
+
+	chs := []AppChange{}
+	for _, key := range keys {
+		one, ok1 := apps1[key]
+		two, ok2 := apps2[key]
+		switch {
+		case !ok1:
+			chs = append(chs, AppChange{Kind: AppNew, BundleID: two.BundleID, TrackID: two.TrackID, Country: key.country, New: two.Version})
+		case !ok2:
+			chs = append(chs, AppChange{Kind: AppGone, BundleID: one.BundleID, TrackID: one.TrackID, Country: key.country, Old: one.Version})
+		default:
+			for _, ch := range CompareApps(one.App, two.App) {
+				ch.Country = key.country
+				chs = append(chs, ch)
+			}
+		}
+	}
+	return chs, nil
+}
+
+// CompareApps returns the changes of the app metadata
+func CompareApps(one, two search.App) []AppChange {
+	chs := []AppChange{}
+	add := func(kind AppChangeKind, old, new string, delta float64) {
+		chs = append(chs, AppChange{
+			Kind:     kind,
+			BundleID: two.BundleID,
+			TrackID:  two.TrackID,
+			Old:      old,
+			New:      new,
+			Delta:    delta,
+		})
+	}
+
+	if one.Version != two.Version {
+		add(AppVersion, one.Version, two.Version, 0)
+	}
+	if one.ReleaseNotes != two.ReleaseNotes {
+		add(AppReleaseNotes, one.ReleaseNotes, two.ReleaseNotes, 0)
+	}
+	if one.Price != two.Price {
+		add(AppPrice, one.FormattedPrice, two.FormattedPrice, two.Price-one.Price)
+	}
+	if one.Currency != two.Currency {
+		add(AppCurrency, one.Currency, two.Currency, 0)
+	}
+	if one.UserRatingCount != two.UserRatingCount {
+		add(AppRatingCount, strconv.Itoa(one.UserRatingCount), strconv.Itoa(two.UserRatingCount), float64(two.UserRatingCount-one.UserRatingCount))
+	}
+	if genre1, genre2 := genre(one), genre(two); genre1 != genre2 {
+		add(AppGenre, genre1, genre2, 0)
+	}
+	if n := newScreenshots(one, two); n > 0 {
+		add(AppScreenshots, strconv.Itoa(screenshots(one)), strconv.Itoa(screenshots(two)), float64(n))
+	}
+	if one.SellerName != two.SellerName {
+		add(AppSeller, one.SellerName, two.SellerName, 0)
+	}
+	if one.ArtistName != two.ArtistName {
+		add(AppArtist, one.ArtistName, two.ArtistName, 0)
+	}
+	return chs
+}
+
+// primary genre and the all genres list
+func genre(app search.App) string {
+	if len(app.Genres) == 0 {
+		return app.PrimaryGenreName
+	}
+	return app.PrimaryGenreName + " (" + strings.Join(app.Genres, ", ") + ")"
+}
+
+func screenshots(app search.App) int {
+	return len(app.ScreenshotUrls) + len(app.IpadScreenshotUrls) + len(app.AppletvScreenshotUrls)
+}
+
+// the number of screenshot urls of two that one doesn't have
+func newScreenshots(one, two search.App) int {
+	old := map[string]struct{}{}
+	for _, urls := range [][]string{one.ScreenshotUrls, one.IpadScreenshotUrls, one.AppletvScreenshotUrls} {
+		for _, url := range urls {
+			old[url] = struct{}{}
+		}
+	}
+
+	n := 0
+	for _, urls := range [][]string{two.ScreenshotUrls, two.IpadScreenshotUrls, two.AppletvScreenshotUrls} {
+		for _, url := range urls {
+			if _, ok := old[url]; !ok {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,161 @@
+package diff_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/Loofort/xscrape/search"
+	"github.com/Loofort/xscrape/search/diff"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareApps(t *testing.T) {
+	base := search.App{
+		BundleID:         "com.x",
+		TrackID:          1,
+		Version:          "1.0",
+		Price:            0.99,
+		FormattedPrice:   "$0.99",
+		Currency:         "USD",
+		UserRatingCount:  10,
+		PrimaryGenreName: "Games",
+		Genres:           []string{"Games", "Puzzle"},
+		ScreenshotUrls:   []string{"s1", "s2"},
+		SellerName:       "X Inc",
+		ArtistName:       "X",
+	}
+
+	tests := []struct {
+		name    string
+		change  func(app *search.App)
+		changes []diff.AppChange
+	}{
+		{
+			name:    "same",
+			change:  func(app *search.App) {},
+			changes: []diff.AppChange{},
+		},
+		{
+			name: "version and notes",
+			change: func(app *search.App) {
+				app.Version = "1.1"
+				app.ReleaseNotes = "fixes"
+			},
+			changes: []diff.AppChange{
+				{Kind: diff.AppVersion, Old: "1.0", New: "1.1"},
+				{Kind: diff.AppReleaseNotes, Old: "", New: "fixes"},
+			},
+		},
+		{
+			name: "price and rating count deltas",
+			change: func(app *search.App) {
+				app.Price = 1.99
+				app.FormattedPrice = "$1.99"
+				app.UserRatingCount = 4
+			},
+			changes: []diff.AppChange{
+				{Kind: diff.AppPrice, Old: "$0.99", New: "$1.99", Delta: 1},
+				{Kind: diff.AppRatingCount, Old: "10", New: "4", Delta: -6},
+			},
+		},
+		{
+			name: "genre list",
+			change: func(app *search.App) {
+				app.Genres = []string{"Games", "Word"}
+			},
+			changes: []diff.AppChange{
+				{Kind: diff.AppGenre, Old: "Games (Games, Puzzle)", New: "Games (Games, Word)"},
+			},
+		},
+		{
+			// the url moved to ipad isn't new
+			name: "new screenshots",
+			change: func(app *search.App) {
+				app.ScreenshotUrls = []string{"s1", "s3"}
+				app.IpadScreenshotUrls = []string{"s2", "p1"}
+			},
+			changes: []diff.AppChange{
+				{Kind: diff.AppScreenshots, Old: "2", New: "4", Delta: 2},
+			},
+		},
+		{
+			name: "removed screenshots",
+			change: func(app *search.App) {
+				app.ScreenshotUrls = []string{"s1"}
+			},
+			changes: []diff.AppChange{},
+		},
+		{
+			name: "seller and artist",
+			change: func(app *search.App) {
+				app.SellerName = "Y Inc"
+				app.ArtistName = "Y"
+				app.Currency = "EUR"
+			},
+			changes: []diff.AppChange{
+				{Kind: diff.AppCurrency, Old: "USD", New: "EUR"},
+				{Kind: diff.AppSeller, Old: "X Inc", New: "Y Inc"},
+				{Kind: diff.AppArtist, Old: "X", New: "Y"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		two := base
+		tt.change(&two)
+		for i := range tt.changes {
+			tt.changes[i].BundleID = "com.x"
+			tt.changes[i].TrackID = 1
+		}
+		require.Equal(t, tt.changes, diff.CompareApps(base, two), tt.name)
+	}
+}
+
+func TestDiffApps(t *testing.T) {
+	write := func(recs ...search.AppRecord) *bytes.Buffer {
+		b := new(bytes.Buffer)
+		enc := json.NewEncoder(b)
+		for _, rec := range recs {
+			require.NoError(t, enc.Encode(rec))
+		}
+		return b
+	}
+	app := func(bundle, version string) search.App {
+		return search.App{BundleID: bundle, TrackID: len(bundle), Version: version}
+	}
+
+	old := write(
+		// the latest record of the app is taken
+		search.AppRecord{Country: "us", App: app("com.same", "1.9")},
+		search.AppRecord{Country: "us", App: app("com.gone", "1.0")},
+		search.AppRecord{Country: "us", App: app("com.x", "1.0")},
+		search.AppRecord{Country: "gb", App: app("com.x", "1.0")},
+		search.AppRecord{Country: "us", App: app("com.same", "2.0")},
+		// the apps without bundle are told apart by track id
+		search.AppRecord{Country: "us", App: search.App{TrackID: 1, Version: "1.0"}},
+		search.AppRecord{Country: "us", App: search.App{TrackID: 2, Version: "1.0"}},
+	)
+	cur := write(
+		search.AppRecord{Country: "us", App: app("com.same", "2.0")},
+		search.AppRecord{Country: "us", App: app("com.x", "1.1")},
+		search.AppRecord{Country: "gb", App: app("com.x", "1.0")},
+		search.AppRecord{Country: "de", App: app("com.x", "1.0")},
+		search.AppRecord{Country: "us", App: app("com.new", "3.0")},
+		search.AppRecord{Country: "us", App: search.App{TrackID: 2, Version: "1.1"}},
+	)
+
+	chs, err := diff.DiffApps(old, cur)
+	require.NoError(t, err)
+	require.Equal(t, []diff.AppChange{
+		{Kind: diff.AppNew, BundleID: "com.x", TrackID: 5, Country: "de", New: "1.0"},
+		{Kind: diff.AppGone, TrackID: 1, Country: "us", Old: "1.0"},
+		{Kind: diff.AppVersion, TrackID: 2, Country: "us", Old: "1.0", New: "1.1"},
+		{Kind: diff.AppGone, BundleID: "com.gone", TrackID: 8, Country: "us", Old: "1.0"},
+		{Kind: diff.AppNew, BundleID: "com.new", TrackID: 7, Country: "us", New: "3.0"},
+		{Kind: diff.AppVersion, BundleID: "com.x", TrackID: 5, Country: "us", Old: "1.0", New: "1.1"},
+	}, chs)
+
+	_, err = diff.DiffApps(bytes.NewBufferString("{bad\n"), cur)
+	require.Error(t, err)
+}
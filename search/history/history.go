@@ -0,0 +1,190 @@
+// Package history builds the time series of search positions over the ordered snapshots.
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/Loofort/xscrape/search"
+)
+
+// Series is the positions of the bundle in the term search, one per snapshot.
+// Zero position means the bundle wasn't found.
+type Series struct {
+	Term      string `json:"term"`
+	BundleID  string `json:"bundle"`
+	Positions []int  `json:"positions"`
+}
+
+// Stats of the series, they're calculated over the snapshots where the bundle was found
+type Stats struct {
+	Best  int     `json:"best"`
+	Worst int     `json:"worst"`
+	Mean  float64 `json:"mean"`
+	// number of snapshots the bundle was found in
+	Seen int `json:"seen"`
+	// number of snapshots the bundle was in top n
+	Top int `json:"top"`
+	// mean absolute position change between the adjacent snapshots
+	Volatility float64 `json:"volatility"`
+}
+
+// Build reads the snapshots from oldest to newest and returns the series ordered by term and bundle
+func Build(snapshots []io.Reader) ([]Series, error) {
+	type key struct {
+		term   string
+		bundle string
+	}
+	index := map[key]*Series{}
+
+	for i, snapshot := range snapshots {
+		r := search.NewReader(snapshot)
+		for r.Next() {
+			for _, s := range r.Searches() {
+				k := key{s.Term, s.BundleID}
+				series, ok := index[k]
+				if !ok {
+					series = &Series{
+						Term:      s.Term,
+						BundleID:  s.BundleID,
+						Positions: make([]int, len(snapshots)),
+					}
+					index[k] = series
+				}
+				// the bundle could be repeated in the old files, the best position is taken
+				if pos := int(s.Position); series.Positions[i] == 0 || pos < series.Positions[i] {
+					series.Positions[i] = pos
+				}
+			}
+		}
+		if err := r.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	ss := make([]Series, 0, len(index))
+	for _, series := range index {
+		ss = append(ss, *series)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		if ss[i].Term != ss[j].Term {
+			return ss[i].Term < ss[j].Term
+		}
+		return ss[i].BundleID < ss[j].BundleID
+	})
+	return ss, nil
+}
+
+// Stats calculates the series stats, top is the n of days-in-top-n
+func (s Series) Stats(top int) Stats {
+	st := Stats{}
+	sum := 0
+	prev := 0
+	changes, moves := 0, 0
+	for _, pos := range s.Positions {
+		if pos == 0 {
+			prev = 0
+			continue
+		}
+
+		st.Seen++
+		sum += pos
+		if st.Best == 0 || pos < st.Best {
+			st.Best = pos
+		}
+		if pos > st.Worst {
+			st.Worst = pos
+		}
+		if pos <= top {
+			st.Top++
+		}
+		if prev != 0 {
+			changes++
+			moves += abs(pos - prev)
+		}
+		prev = pos
+	}
+
+	if st.Seen > 0 {
+		st.Mean = float64(sum) / float64(st.Seen)
+	}
+	if changes > 0 {
+		st.Volatility = float64(moves) / float64(changes)
+	}
+	return st
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func round(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// WriteCSV writes the series with stats, a column per snapshot named by label
+func WriteCSV(w io.Writer, labels []string, ss []Series, top int) error {
+	cw := csv.NewWriter(w)
+	header := []string{"term", "bundle", "best", "worst", "mean", "seen", "top" + strconv.Itoa(top), "volatility"}
+	if err := cw.Write(append(header, labels...)); err != nil {
+		return err
+	}
+
+	for _, s := range ss {
+		st := s.Stats(top)
+		record := []string{
+			s.Term,
+			s.BundleID,
+			strconv.Itoa(st.Best),
+			strconv.Itoa(st.Worst),
+			round(st.Mean),
+			strconv.Itoa(st.Seen),
+			strconv.Itoa(st.Top),
+			round(st.Volatility),
+		}
+		for _, pos := range s.Positions {
+			cell := ""
+			if pos > 0 {
+				cell = strconv.Itoa(pos)
+			}
+			record = append(record, cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the series with stats as one json document
+func WriteJSON(w io.Writer, labels []string, ss []Series, top int) error {
+	type jsonSeries struct {
+		Series
+		Stats
+	}
+	doc := struct {
+		Snapshots []string     `json:"snapshots"`
+		Top       int          `json:"top_n"`
+		Series    []jsonSeries `json:"series"`
+	}{
+		Snapshots: labels,
+		Top:       top,
+		Series:    make([]jsonSeries, 0, len(ss)),
+	}
+	for _, s := range ss {
+		doc.Series = append(doc.Series, jsonSeries{s, s.Stats(top)})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
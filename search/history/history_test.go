@@ -0,0 +1,60 @@
+package history_test
+
+import (
+	"testing"
+
+	"github.com/Loofort/xscrape/search/history"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		positions []int
+		top       int
+		stats     history.Stats
+	}{
+		{
+			name:      "never seen",
+			positions: []int{0, 0},
+			top:       10,
+			stats:     history.Stats{},
+		},
+		{
+			name:      "seen once",
+			positions: []int{0, 4, 0},
+			top:       3,
+			stats:     history.Stats{Best: 4, Worst: 4, Mean: 4, Seen: 1},
+		},
+		{
+			name:      "adjacent moves",
+			positions: []int{5, 2, 8},
+			top:       5,
+			stats:     history.Stats{Best: 2, Worst: 8, Mean: 5, Seen: 3, Top: 2, Volatility: 4.5},
+		},
+		{
+			// the move across the gap isn't counted
+			name:      "volatility resets across gaps",
+			positions: []int{1, 0, 20, 21, 0, 0, 3},
+			top:       10,
+			stats:     history.Stats{Best: 1, Worst: 21, Mean: 11.25, Seen: 4, Top: 2, Volatility: 1},
+		},
+		{
+			name:      "top is inclusive",
+			positions: []int{10, 11, 10},
+			top:       10,
+			stats:     history.Stats{Best: 10, Worst: 11, Mean: 31.0 / 3, Seen: 3, Top: 2, Volatility: 1},
+		},
+		{
+			name:      "zero top",
+			positions: []int{1, 1},
+			top:       0,
+			stats:     history.Stats{Best: 1, Worst: 1, Mean: 1, Seen: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		s := history.Series{Term: "t", BundleID: "b", Positions: tt.positions}
+		require.Equal(t, tt.stats, s.Stats(tt.top), tt.name)
+	}
+}
@@ -0,0 +1,121 @@
+// Package lookup enriches bundle and track ids with app metadata using itunes lookup endpoint.
+package lookup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/search"
+)
+
+const lhost = "https://itunes.apple.com/"
+
+// BatchSize is the number of ids requested at once
+const BatchSize = 100
+
+type serp struct {
+	ResultCount int
+	Results     []search.App
+}
+
+// Bundles looks up the apps by bundle ids in cfg.Country storefront.
+// The unknown ids are missed in the result.
+func Bundles(cfg itunes.Config, ids []string) ([]search.App, error) {
+	return BundlesContext(context.Background(), cfg, ids)
+}
+
+// the apps of the batches done before the error are returned with it
+func BundlesContext(ctx context.Context, cfg itunes.Config, ids []string) ([]search.App, error) {
+	return lookup(ctx, cfg, "bundleId", ids)
+}
+
+// Tracks looks up the apps by track ids in cfg.Country storefront.
+func Tracks(cfg itunes.Config, ids []int) ([]search.App, error) {
+	return TracksContext(context.Background(), cfg, ids)
+}
+
+func TracksContext(ctx context.Context, cfg itunes.Config, ids []int) ([]search.App, error) {
+	sids := make([]string, 0, len(ids))
+	for _, id := range ids {
+		sids = append(sids, strconv.Itoa(id))
+	}
+	return lookup(ctx, cfg, "id", sids)
+}
+
+func lookup(ctx context.Context, cfg itunes.Config, param string, ids []string) ([]search.App, error) {
+	apps := []search.App{}
+	for len(ids) > 0 {
+		n := BatchSize
+		if n > len(ids) {
+			n = len(ids)
+		}
+		batch := ids[:n]
+		ids = ids[n:]
+
+		var as []search.App
+		err := cfg.Retry.DoContext(ctx, func() (err error) {
+			as, err = Batch(ctx, cfg, param, batch)
+			return err
+		})
+		if err != nil {
+			return apps, fmt.Errorf("can't lookup %s %s: %w", param, batch[0], err)
+		}
+		apps = append(apps, as...)
+	}
+	return apps, nil
+}
+
+// Batch requests the ids at once, param is bundleId or id
+func Batch(ctx context.Context, cfg itunes.Config, param string, ids []string) ([]search.App, error) {
+	// https://itunes.apple.com/lookup?entity=software&bundleId=com.a,com.b&country=us
+	v := url.Values{}
+	v.Set("entity", "software")
+	v.Set(param, strings.Join(ids, ","))
+	if cfg.Country != "" {
+		v.Set("country", cfg.Country)
+	}
+	if cfg.Lang != "" {
+		v.Set("lang", cfg.Lang)
+	}
+	url := cfg.URL(lhost, "/lookup", v)
+
+	resp, err := cfg.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, itunes.NewStatusError(resp)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	dec.DisallowUnknownFields()
+
+	se := serp{}
+	if err := dec.Decode(&se); err != nil {
+		cfg.Uncache(url)
+		// unknown field means apple changed the App
+		if strings.Contains(err.Error(), "unknown field") {
+			return nil, fmt.Errorf("%w: %v; url: %s", itunes.ErrSchema, err, url)
+		}
+		cfg.Limiter.Slowdown()
+		return nil, fmt.Errorf("%w: unable parse resp: %v; url: %s", itunes.ErrEnvelope, err, url)
+	}
+	cfg.Limiter.Recover()
+
+	// the id of other entity kind could be given
+	apps := make([]search.App, 0, len(se.Results))
+	for _, app := range se.Results {
+		if app.Kind == "software" || app.WrapperType == "software" {
+			apps = append(apps, app)
+		}
+	}
+	return apps, nil
+}
@@ -0,0 +1,39 @@
+package lookup_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/itunestest"
+	"github.com/Loofort/xscrape/search/lookup"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundles(t *testing.T) {
+	s := itunestest.NewServer()
+	defer s.Close()
+
+	ids := []string{}
+	for i := 0; i < lookup.BatchSize+5; i++ {
+		ids = append(ids, fmt.Sprintf("com.app%d", i))
+	}
+	ids = append(ids, "unknown.app")
+
+	cfg := s.Config()
+	cfg.Retry = itunes.NewRetry(2, 0, time.Millisecond, time.Millisecond)
+	s.Script(strings.Join(ids[:lookup.BatchSize], ","), itunestest.Unavailable)
+
+	apps, err := lookup.Bundles(cfg, ids)
+	require.NoError(t, err)
+	require.Len(t, apps, lookup.BatchSize+5)
+	require.Equal(t, "com.app0", apps[0].BundleID)
+	require.Equal(t, 2, s.Requests(strings.Join(ids[:lookup.BatchSize], ",")))
+
+	apps, err = lookup.Tracks(cfg, []int{1, 2})
+	require.NoError(t, err)
+	require.Len(t, apps, 2)
+	require.Equal(t, 2, apps[1].TrackID)
+}
@@ -1,42 +1,66 @@
 package scrape
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 
+	"github.com/Loofort/xscrape/itunes"
 	"github.com/Loofort/xscrape/search"
 )
 
 type Pipe interface {
 	Pull() (string, func())
+	PullContext(ctx context.Context) (string, func())
+	Push(terms []string)
 }
 
-// return true when no more query to scrape
-func Iterate(client *http.Client, pipe Pipe, storage io.Writer, country string) (bool, error) {
+// return true when no more query to scrape.
+// the apps metadata is written to apps, it could be nil.
+func Iterate(cfg itunes.Config, pipe Pipe, storage io.Writer, apps *search.AppWriter) (bool, error) {
+	return IterateContext(context.Background(), cfg, pipe, storage, apps)
+}
+
+// also returns true when ctx is done, the interrupted term goes back to the pipe
+func IterateContext(ctx context.Context, cfg itunes.Config, pipe Pipe, storage io.Writer, apps *search.AppWriter) (bool, error) {
 	// get new query to proccess
-	term, done := pipe.Pull()
+	term, done := pipe.PullContext(ctx)
 	if done == nil {
-		return true, nil
+		return true, ctx.Err()
 	}
 	defer done()
 
 	// scrape search from itunes
-	apps, err := search.Scrape(client, term, country, 200)
+	var as []search.App
+	err := cfg.Retry.DoContext(ctx, func() (err error) {
+		as, err = search.ScrapeContext(ctx, cfg, term, 200)
+		return err
+	})
+	if err != nil && ctx.Err() != nil {
+		pipe.Push([]string{term})
+		return true, ctx.Err()
+	}
 	if err != nil {
+		if itunes.Transient(err) && cfg.Retry.Requeue(term) {
+			pipe.Push([]string{term})
+		} else if derr := cfg.Retry.Bury(term, cfg.Country, err); derr != nil {
+			err = fmt.Errorf("%v; dead-letter: %v", err, derr)
+		}
 		return false, fmt.Errorf("can't scrape '%s': %v", term, err)
 	}
 
 	// save search and apps
-	if len(apps) == 0 {
+	if len(as) == 0 {
 		return false, nil
 	}
 
 	// save search
-	b := search.ToBytes(term, apps)
+	b := search.ToBytes(term, as)
 	storage.Write(b)
 
-	// todo:
 	// save apps
+	if err := apps.Write(cfg.Country, as); err != nil {
+		return false, fmt.Errorf("can't save apps of '%s': %v", term, err)
+	}
 	return false, nil
 }
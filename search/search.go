@@ -3,18 +3,23 @@ package search
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Loofort/xscrape/iostuff"
+	"github.com/Loofort/xscrape/itunes"
 )
 
+const shost = "https://itunes.apple.com/"
+
 type Search struct {
 	Position byte
 	BundleID string
@@ -31,7 +36,12 @@ type serp struct {
 	Results     []App
 }
 
-func Scrape(client *http.Client, term, country string, limit int) ([]App, error) {
+func Scrape(cfg itunes.Config, term string, limit int) ([]App, error) {
+	return ScrapeContext(context.Background(), cfg, term, limit)
+}
+
+// the request is cancelled when ctx is done
+func ScrapeContext(ctx context.Context, cfg itunes.Config, term string, limit int) ([]App, error) {
 	// https://itunes.apple.com/search?country=us&entity=software&term=flappy
 	// skip media and limit (=50) and attribute
 	v := url.Values{}
@@ -39,12 +49,15 @@ func Scrape(client *http.Client, term, country string, limit int) ([]App, error)
 	v.Set("term", term)
 	v.Set("limit", strconv.Itoa(limit))
 
-	if country != "" {
-		v.Set("country", country)
+	if cfg.Country != "" {
+		v.Set("country", cfg.Country)
+	}
+	if cfg.Lang != "" {
+		v.Set("lang", cfg.Lang)
 	}
-	url := "https://itunes.apple.com/search?" + v.Encode()
+	url := cfg.URL(shost, "/search", v)
 
-	resp, err := client.Get(url)
+	resp, err := cfg.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -52,12 +65,7 @@ func Scrape(client *http.Client, term, country string, limit int) ([]App, error)
 
 	// expect status 200
 	if resp.StatusCode != http.StatusOK {
-		body, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			errmsg := fmt.Sprintf("cant dump resp: %v", err)
-			body = []byte(errmsg)
-		}
-		return nil, fmt.Errorf("unexpected http status %d; dump: %s", resp.StatusCode, body)
+		return nil, itunes.NewStatusError(resp)
 	}
 
 	dec := json.NewDecoder(resp.Body)
@@ -65,14 +73,22 @@ func Scrape(client *http.Client, term, country string, limit int) ([]App, error)
 
 	se := serp{}
 	if err := dec.Decode(&se); err != nil {
+		cfg.Uncache(url)
+		// unknown field means apple changed the App
+		if strings.Contains(err.Error(), "unknown field") {
+			return nil, fmt.Errorf("%w: %v; url: %s", itunes.ErrSchema, err, url)
+		}
+		cfg.Limiter.Slowdown()
 		//body, err := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unable parse resp: %v; url: %s", err, url)
+		return nil, fmt.Errorf("%w: unable parse resp: %v; url: %s", itunes.ErrEnvelope, err, url)
 	}
+	cfg.Limiter.Recover()
 
 	seen := make(map[string]struct{}, limit)
 	for _, app := range se.Results {
 		if _, ok := seen[app.BundleID]; ok {
-			return nil, fmt.Errorf("duplicate bundle in response: %s", app.BundleID)
+			cfg.Uncache(url)
+			return nil, fmt.Errorf("%w: %s", itunes.ErrDuplicate, app.BundleID)
 		}
 		seen[app.BundleID] = struct{}{}
 	}
@@ -180,30 +196,120 @@ func Sort(ss []Search) {
 }
 
 func FromReader(reader io.Reader) ([]Search, error) {
-	scanner := bufio.NewScanner(reader)
 	ss := []Search{}
-	for scanner.Scan() {
-		line := scanner.Text()
+	r := NewReader(reader)
+	for r.Next() {
+		ss = append(ss, r.Searches()...)
+	}
+
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
 
-		pices := strings.SplitN(line, "\t", 2)
-		if len(pices) != 2 {
-			return nil, fmt.Errorf("incorrect line: %s", line)
+	return ss, nil
+}
+
+// ParseLine parses the term line produced by ToBytes, the position starts from 1
+func ParseLine(line string) ([]Search, error) {
+	pices := strings.SplitN(line, "\t", 2)
+	if len(pices) != 2 {
+		return nil, fmt.Errorf("incorrect line: %s", line)
+	}
+
+	bundles := strings.Split(pices[1], " ")
+	ss := make([]Search, 0, len(bundles))
+	for i, bundleID := range bundles {
+		search := Search{
+			Position: byte(i) + 1,
+			BundleID: bundleID,
+			Term:     pices[0],
 		}
+		ss = append(ss, search)
+	}
+	return ss, nil
+}
+
+// Reader reads search file term by term in constant memory.
+//
+//	r := NewReader(f)
+//	for r.Next() {
+//		ss := r.Searches()
+//	}
+//	err := r.Err()
+type Reader struct {
+	scanner  *bufio.Scanner
+	searches []Search
+	line     int
+	skipped  int
+	err      error
 
-		bundles := strings.Split(pices[1], " ")
-		for i, bundleID := range bundles {
-			search := Search{
-				Position: byte(i) + 1,
-				BundleID: bundleID,
-				Term:     pices[0],
-			}
-			ss = append(ss, search)
+	// SkipBad makes Next skip the malformed lines instead of failing, they're counted by Skipped
+	SkipBad bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	// the line holds up to 200 bundles
+	scanner.Buffer(nil, 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next reads the next term line, it returns false at the end of input or on error
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	for r.scanner.Scan() {
+		r.line++
+		ss, err := ParseLine(r.scanner.Text())
+		if err == nil {
+			r.searches = ss
+			return true
 		}
+		if !r.SkipBad {
+			r.err = &iostuff.LineError{Line: r.line, Err: err}
+			return false
+		}
+		r.skipped++
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if err := r.scanner.Err(); err != nil {
+		r.err = &iostuff.LineError{Line: r.line + 1, Err: err}
 	}
+	return false
+}
 
-	return ss, nil
+// Searches returns the searches of the term read by the last Next, in position order
+func (r *Reader) Searches() []Search {
+	return r.searches
+}
+
+// Line returns the number of the last read line
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Skipped returns the number of the malformed lines skipped so far
+func (r *Reader) Skipped() int {
+	return r.skipped
+}
+
+// Err returns the first error, it's *iostuff.LineError with the line number
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// SortKey returns the term of the search line
+func SortKey(line string) (string, error) {
+	pices := strings.SplitN(line, "\t", 2)
+	if len(pices) != 2 {
+		return "", fmt.Errorf("incorrect line: %s", line)
+	}
+	return pices[0], nil
+}
+
+// SortLines sorts search file of any size by term, at most chunk lines are kept in memory.
+func SortLines(r io.Reader, w io.Writer, chunk int, tmpdir string) error {
+	return iostuff.SortLines(r, w, SortKey, chunk, tmpdir)
 }
@@ -0,0 +1,64 @@
+package search_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Loofort/xscrape/itunes"
+	"github.com/Loofort/xscrape/itunestest"
+	"github.com/Loofort/xscrape/search"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrape(t *testing.T) {
+	s := itunestest.NewServer()
+	defer s.Close()
+
+	cases := []struct {
+		sc  itunestest.Scenario
+		err error
+		n   int
+	}{
+		{itunestest.OK, nil, 10},
+		{itunestest.TooMany, nil, 11},
+		{itunestest.Unavailable, itunes.ErrUnavailable, 0},
+		{itunestest.Malformed, itunes.ErrEnvelope, 0},
+		{itunestest.Duplicate, itunes.ErrDuplicate, 0},
+		{itunestest.UnknownField, itunes.ErrSchema, 0},
+	}
+
+	for _, c := range cases {
+		term := "term " + c.sc.String()
+		s.Script(term, c.sc)
+
+		apps, err := search.Scrape(s.Config(), term, 10)
+		if c.err == nil {
+			require.NoError(t, err, c.sc.String())
+		} else {
+			require.True(t, errors.Is(err, c.err), "%s: %v", c.sc, err)
+		}
+		require.Len(t, apps, c.n, c.sc.String())
+		require.Equal(t, 1, s.Requests(term))
+	}
+}
+
+func TestAppWriter(t *testing.T) {
+	b := new(bytes.Buffer)
+	aw := search.NewAppWriter(b)
+	require.NoError(t, aw.Write("us", itunestest.DefaultApps("a", 3)))
+	require.NoError(t, aw.Write("us", itunestest.DefaultApps("a", 5)))
+	// the other storefront gets its own records
+	require.NoError(t, aw.Write("gb", itunestest.DefaultApps("a", 2)))
+
+	ar := search.NewAppReader(b)
+	apps := []string{}
+	for ar.Next() {
+		apps = append(apps, ar.Record().Country+" "+ar.Record().BundleID)
+	}
+	require.NoError(t, ar.Err())
+	require.Equal(t, []string{
+		"us com.a.app0", "us com.a.app1", "us com.a.app2", "us com.a.app3", "us com.a.app4",
+		"gb com.a.app0", "gb com.a.app1",
+	}, apps)
+}